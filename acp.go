@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// acpProtocolVersion is the Agent Client Protocol version yagi speaks.
+const acpProtocolVersion = 1
+
+// acpAgent implements the agent side of the Agent Client Protocol on top of
+// the STDIO JSON-RPC peer.
+type acpAgent struct {
+	peer *stdioPeer
+
+	mu          sync.Mutex
+	sessions    map[string]*acpSession
+	nextSession int
+
+	connectMu sync.Mutex // serializes connecting the sessions' MCP servers
+}
+
+type acpSession struct {
+	id  string
+	mcp []*mcpConnection // MCP servers this session connected
+
+	prompt   sync.Mutex // held while a prompt turn runs
+	messages []openai.ChatCompletionMessage
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	calls    []*acpToolCall
+	nextCall int
+}
+
+type acpToolCall struct {
+	id       string
+	name     string
+	started  bool
+	done     bool
+	progress strings.Builder
+}
+
+type acpSessionKey struct{}
+
+// ACP content block, a subset of MCP content.
+type acpContent struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text,omitempty"`
+	Data     string       `json:"data,omitempty"`
+	MIMEType string       `json:"mimeType,omitempty"`
+	URI      string       `json:"uri,omitempty"`
+	Name     string       `json:"name,omitempty"`
+	Resource *acpResource `json:"resource,omitempty"`
+}
+
+type acpResource struct {
+	URI      string `json:"uri"`
+	Text     string `json:"text,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+}
+
+type acpToolCallContent struct {
+	Type    string     `json:"type"`
+	Content acpContent `json:"content"`
+}
+
+type acpUpdate struct {
+	SessionUpdate string      `json:"sessionUpdate"`
+	Content       any         `json:"content,omitempty"`
+	ToolCallID    string      `json:"toolCallId,omitempty"`
+	Title         string      `json:"title,omitempty"`
+	Kind          string      `json:"kind,omitempty"`
+	Status        string      `json:"status,omitempty"`
+	RawInput      interface{} `json:"rawInput,omitempty"`
+	RawOutput     interface{} `json:"rawOutput,omitempty"`
+}
+
+type acpPermissionOption struct {
+	OptionID string `json:"optionId"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+}
+
+type acpEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type acpMCPServer struct {
+	Type    string      `json:"type,omitempty"` // "http", "sse" or empty for stdio
+	Name    string      `json:"name"`
+	Command string      `json:"command,omitempty"`
+	Args    []string    `json:"args,omitempty"`
+	Env     []acpEnvVar `json:"env,omitempty"`
+	URL     string      `json:"url,omitempty"`
+	Headers []acpEnvVar `json:"headers,omitempty"`
+}
+
+func newACPAgent() *acpAgent {
+	a := &acpAgent{peer: newSTDIOPeer(), sessions: make(map[string]*acpSession)}
+	a.peer.methods = map[string]stdioMethod{
+		"initialize":     a.initialize,
+		"authenticate":   a.authenticate,
+		"session/new":    a.newSession,
+		"session/prompt": a.prompt,
+	}
+	a.peer.notifications["session/cancel"] = a.cancel
+	a.peer.approve = a.requestPermission
+	return a
+}
+
+func runACPMode() error {
+	agent := newACPAgent()
+	editorPeer = agent.peer
+	defer agent.closeSessions()
+	return agent.peer.serve(os.Stdin)
+}
+
+// closeSessions ends every session and disconnects its MCP servers.
+func (a *acpAgent) closeSessions() {
+	a.mu.Lock()
+	sessions := a.sessions
+	a.sessions = make(map[string]*acpSession)
+	a.mu.Unlock()
+	for _, s := range sessions {
+		for _, conn := range s.mcp {
+			disconnectMCPServer(conn)
+		}
+	}
+}
+
+func (a *acpAgent) initialize(p *stdioPeer, ctx context.Context, req JSONRPCRequest) (any, error) {
+	var params struct {
+		ProtocolVersion    int             `json:"protocolVersion"`
+		ClientCapabilities json.RawMessage `json:"clientCapabilities"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+	// ACP clients do not answer yagi's own requests such as
+	// elicitation/create.
+	p.mu.Lock()
+	p.clientCaps = map[string]json.RawMessage{}
+	p.mu.Unlock()
+
+	return map[string]any{
+		"protocolVersion": acpProtocolVersion,
+		"agentCapabilities": map[string]any{
+			"loadSession": false,
+			"promptCapabilities": map[string]bool{
+				"image":           supportsVision(currentModelName()),
+				"audio":           false,
+				"embeddedContext": true,
+			},
+		},
+		"authMethods": []any{},
+	}, nil
+}
+
+func (a *acpAgent) authenticate(p *stdioPeer, ctx context.Context, req JSONRPCRequest) (any, error) {
+	return map[string]any{}, nil
+}
+
+// newSession starts a conversation and connects the MCP servers the client
+// passes along. Their tools join yagi's tool registry until the session
+// ends; a server that is already connected is shared. Tools run in yagi's
+// working directory, so a session for another directory is rejected.
+func (a *acpAgent) newSession(p *stdioPeer, ctx context.Context, req JSONRPCRequest) (any, error) {
+	var params struct {
+		Cwd        string         `json:"cwd"`
+		MCPServers []acpMCPServer `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+	if params.Cwd != "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if !sameDir(params.Cwd, wd) {
+			return nil, invalidParams(fmt.Errorf("cwd %s is not yagi's working directory %s; start yagi in that directory", params.Cwd, wd))
+		}
+	}
+
+	var conns []*mcpConnection
+	a.connectMu.Lock()
+	for _, s := range params.MCPServers {
+		if findMCPConnection(s.Name) != nil {
+			continue
+		}
+		conn, err := connectMCPServer(ctx, s.Name, s.config())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: MCP server %s: %v\n", s.Name, err)
+		}
+		conns = append(conns, conn)
+	}
+	a.connectMu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextSession++
+	id := fmt.Sprintf("session-%d", a.nextSession)
+	a.sessions[id] = &acpSession{id: id, mcp: conns}
+	return map[string]string{"sessionId": id}, nil
+}
+
+func sameDir(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
+
+func (s acpMCPServer) config() MCPServerConfig {
+	sc := MCPServerConfig{Command: s.Command, Args: s.Args, URL: s.URL}
+	if s.Type == "sse" {
+		sc.Transport = "sse"
+	}
+	for _, e := range s.Env {
+		if sc.Env == nil {
+			sc.Env = map[string]string{}
+		}
+		sc.Env[e.Name] = e.Value
+	}
+	for _, h := range s.Headers {
+		if sc.Headers == nil {
+			sc.Headers = map[string]string{}
+		}
+		sc.Headers[h.Name] = h.Value
+	}
+	return sc
+}
+
+func (a *acpAgent) session(id string) (*acpSession, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[id]
+	if !ok {
+		return nil, &JSONRPCError{Code: codeInvalidParams, Message: "Invalid params", Data: "unknown session: " + id}
+	}
+	return s, nil
+}
+
+// prompt runs one prompt turn. A cancelled turn ends with the "cancelled"
+// stop reason rather than an error, and leaves the history unchanged.
+func (a *acpAgent) prompt(p *stdioPeer, ctx context.Context, req JSONRPCRequest) (any, error) {
+	var params struct {
+		SessionID string       `json:"sessionId"`
+		Prompt    []acpContent `json:"prompt"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+	s, err := a.session(params.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.prompt.TryLock() {
+		return nil, &JSONRPCError{Code: codeInvalidRequest, Message: "Invalid request", Data: "a prompt is already running in " + s.id}
+	}
+	defer s.prompt.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancel = cancel
+	s.calls = nil
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.cancel = nil
+		s.mu.Unlock()
+		cancel()
+	}()
+	ctx = context.WithValue(ctx, acpSessionKey{}, s)
+
+	opts := a.chatOptions(s)
+	opts.Autonomous = true
+	pinActiveModel(&opts)
+	history := append(append([]openai.ChatCompletionMessage(nil), s.messages...), acpUserMessage(params.Prompt))
+	_, history, err = eng.Chat(ctx, history, opts)
+	if ctx.Err() != nil {
+		return map[string]string{"stopReason": "cancelled"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.messages = history
+	return map[string]string{"stopReason": "end_turn"}, nil
+}
+
+func (a *acpAgent) cancel(params json.RawMessage) {
+	var p struct {
+		SessionID string `json:"sessionId"`
+	}
+	if json.Unmarshal(params, &p) != nil {
+		return
+	}
+	s, err := a.session(p.SessionID)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+func acpUserMessage(blocks []acpContent) openai.ChatCompletionMessage {
+	var text strings.Builder
+	var images []openai.ChatMessagePart
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			text.WriteString(b.Text)
+		case "resource":
+			if b.Resource != nil {
+				fmt.Fprintf(&text, "\n<resource uri=%q>\n%s\n</resource>\n", b.Resource.URI, b.Resource.Text)
+			}
+		case "resource_link":
+			fmt.Fprintf(&text, "\n[resource link: %s <%s>]\n", b.Name, b.URI)
+		case "image":
+			images = append(images, openai.ChatMessagePart{
+				Type:     openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{URL: "data:" + b.MIMEType + ";base64," + b.Data},
+			})
+		}
+	}
+	if len(images) == 0 {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: text.String()}
+	}
+	parts := append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: text.String()}}, images...)
+	return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: parts}
+}
+
+func (a *acpAgent) update(s *acpSession, u acpUpdate) {
+	writeJSONRPCNotification("session/update", map[string]any{"sessionId": s.id, "update": u})
+}
+
+// chatOptions reports a prompt turn as session/update notifications.
+func (a *acpAgent) chatOptions(s *acpSession) engine.ChatOptions {
+	return engine.ChatOptions{
+		OnContent: func(text string) {
+			a.update(s, acpUpdate{SessionUpdate: "agent_message_chunk", Content: acpContent{Type: "text", Text: text}})
+		},
+		OnReasoning: func(text string) {
+			a.update(s, acpUpdate{SessionUpdate: "agent_thought_chunk", Content: acpContent{Type: "text", Text: text}})
+		},
+		OnToolCall: func(name, arguments string) {
+			call := s.addCall(name)
+			var input any
+			if json.Unmarshal([]byte(arguments), &input) != nil {
+				input = arguments
+			}
+			a.update(s, acpUpdate{SessionUpdate: "tool_call", ToolCallID: call.id, Title: name, Kind: acpToolKind(name), Status: "pending", RawInput: input})
+		},
+		OnToolProgress: func(name, text string) {
+			s.mu.Lock()
+			call := s.findCall(name, false)
+			var progress string
+			if call != nil {
+				call.started = true
+				call.progress.WriteString(text)
+				progress = call.progress.String()
+			}
+			s.mu.Unlock()
+			if call != nil {
+				a.update(s, acpUpdate{SessionUpdate: "tool_call_update", ToolCallID: call.id, Status: "in_progress", Content: textToolContent(progress)})
+			}
+		},
+		OnToolOutput: func(name string, output engine.ToolOutput) {
+			if call := s.finishCall(name); call != nil {
+				a.update(s, acpUpdate{SessionUpdate: "tool_call_update", ToolCallID: call.id, Status: "completed", Content: textToolContent(output.Text), RawOutput: output.Structured})
+			}
+		},
+		OnToolError: func(name, errMsg string) {
+			if call := s.finishCall(name); call != nil {
+				a.update(s, acpUpdate{SessionUpdate: "tool_call_update", ToolCallID: call.id, Status: "failed", Content: textToolContent(errMsg)})
+			}
+		},
+	}
+}
+
+func textToolContent(text string) []acpToolCallContent {
+	return []acpToolCallContent{{Type: "content", Content: acpContent{Type: "text", Text: text}}}
+}
+
+func (s *acpSession) addCall(name string) *acpToolCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextCall++
+	call := &acpToolCall{id: fmt.Sprintf("call-%d", s.nextCall), name: name}
+	s.calls = append(s.calls, call)
+	return call
+}
+
+// findCall returns the oldest unfinished call of the named tool. The engine
+// reports calls and results by name in the order of the model's tool calls,
+// so this pairs them up. With unstarted set, calls already running are
+// skipped.
+func (s *acpSession) findCall(name string, unstarted bool) *acpToolCall {
+	for _, c := range s.calls {
+		if c.name == name && !c.done && !(unstarted && c.started) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *acpSession) finishCall(name string) *acpToolCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := s.findCall(name, false)
+	if call != nil {
+		call.done = true
+	}
+	return call
+}
+
+// acpToolKind maps yagi's tool names onto ACP tool kinds, which editors use
+// to pick icons.
+func acpToolKind(name string) string {
+	switch {
+	case strings.Contains(name, "read"), strings.Contains(name, "recall"), strings.HasPrefix(name, "get"):
+		return "read"
+	case strings.Contains(name, "edit"), strings.Contains(name, "write"):
+		return "edit"
+	case strings.Contains(name, "delete"):
+		return "delete"
+	case strings.Contains(name, "search"), strings.Contains(name, "glob"), strings.HasPrefix(name, "list"):
+		return "search"
+	case strings.Contains(name, "command"), strings.Contains(name, "exec"), strings.Contains(name, "shell"):
+		return "execute"
+	case strings.Contains(name, "fetch"):
+		return "fetch"
+	}
+	return "other"
+}
+
+// requestPermission asks the client with session/request_permission before
+// a tool runs.
+func (a *acpAgent) requestPermission(ctx context.Context, name, args, source string) (ToolApproveResult, error) {
+	s, ok := ctx.Value(acpSessionKey{}).(*acpSession)
+	if !ok {
+		return ToolApproveResult{}, errEditorUnavailable
+	}
+	s.mu.Lock()
+	call := s.findCall(name, true)
+	if call != nil {
+		call.started = true
+	}
+	s.mu.Unlock()
+	if call == nil {
+		return ToolApproveResult{}, errors.New("unknown tool call: " + name)
+	}
+
+	var input any
+	if json.Unmarshal([]byte(args), &input) != nil {
+		input = args
+	}
+	result, err := a.peer.call(ctx, "session/request_permission", map[string]any{
+		"sessionId": s.id,
+		"toolCall": acpUpdate{
+			ToolCallID: call.id,
+			Title:      fmt.Sprintf("%s (%s)", name, source),
+			Kind:       acpToolKind(name),
+			Status:     "pending",
+			RawInput:   input,
+		},
+		"options": []acpPermissionOption{
+			{OptionID: "allow_once", Name: "Allow", Kind: "allow_once"},
+			{OptionID: "allow_always", Name: "Always allow", Kind: "allow_always"},
+			{OptionID: "reject_once", Name: "Reject", Kind: "reject_once"},
+		},
+	})
+	if err != nil {
+		return ToolApproveResult{}, err
+	}
+	var res struct {
+		Outcome struct {
+			Outcome  string `json:"outcome"`
+			OptionID string `json:"optionId"`
+		} `json:"outcome"`
+	}
+	if err := json.Unmarshal(result, &res); err != nil {
+		return ToolApproveResult{}, err
+	}
+	approved := res.Outcome.Outcome == "selected" && strings.HasPrefix(res.Outcome.OptionID, "allow")
+	if approved {
+		a.update(s, acpUpdate{SessionUpdate: "tool_call_update", ToolCallID: call.id, Status: "in_progress"})
+	}
+	return ToolApproveResult{Approved: approved, Always: res.Outcome.OptionID == "allow_always"}, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// newSessionRequest is a session/new request for yagi's working directory.
+func newSessionRequest(t *testing.T, id int, mcpServers string) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := json.Marshal(wd)
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"session/new","params":{"cwd":%s,"mcpServers":%s}}`, id, cwd, mcpServers)
+}
+
+func TestACP_PromptTurn(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("write_note", `{"text":"hi"}`)}},
+		fakeTurn{Content: "noted"},
+	)
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test", Approver: newToolApprover()})
+	eng.RegisterTool("write_note", "Write a note", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return "written", nil
+	}, false)
+	c := startPeer(t, newACPAgent().peer)
+
+	c.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":1,"clientCapabilities":{}}}`)
+	if res := c.response(0)["result"].(map[string]any); res["protocolVersion"] != 1.0 {
+		t.Errorf("initialize = %v", res)
+	}
+	c.send(newSessionRequest(t, 1, "[]"))
+	sessionID := c.response(1)["result"].(map[string]any)["sessionId"].(string)
+
+	c.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` + sessionID + `","prompt":[{"type":"text","text":"take a note"},{"type":"resource","resource":{"uri":"file:///a.txt","text":"A"}}]}}`)
+	var updates []string
+	var callID string
+	for {
+		m := c.recv()
+		if m["method"] == "session/request_permission" {
+			tc := m["params"].(map[string]any)["toolCall"].(map[string]any)
+			if tc["toolCallId"] != callID || tc["kind"] != "edit" {
+				t.Errorf("permission request = %v", m)
+			}
+			c.send(`{"jsonrpc":"2.0","id":"` + m["id"].(string) + `","result":{"outcome":{"outcome":"selected","optionId":"allow_once"}}}`)
+			updates = append(updates, "permission")
+			continue
+		}
+		if m["method"] == "session/update" {
+			params := m["params"].(map[string]any)
+			u := params["update"].(map[string]any)
+			if params["sessionId"] != sessionID {
+				t.Errorf("update for wrong session: %v", m)
+			}
+			kind := u["sessionUpdate"].(string)
+			if kind == "tool_call" {
+				callID = u["toolCallId"].(string)
+			}
+			if status, ok := u["status"].(string); ok {
+				kind += ":" + status
+			}
+			updates = append(updates, kind)
+			continue
+		}
+		if m["id"] != 2.0 || m["result"].(map[string]any)["stopReason"] != "end_turn" {
+			t.Errorf("prompt result = %v", m)
+		}
+		break
+	}
+	want := "[tool_call:pending permission tool_call_update:in_progress tool_call_update:completed agent_message_chunk]"
+	if got := "[" + strings.Join(updates, " ") + "]"; got != want {
+		t.Errorf("updates = %s, want %s", got, want)
+	}
+	if msg := llm.Requests()[0].Messages[0].Content; msg != "take a note\n<resource uri=\"file:///a.txt\">\nA\n</resource>\n" {
+		t.Errorf("user message = %q", msg)
+	}
+}
+
+func TestACP_Cancel(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{ToolCalls: []openai.ToolCall{toolCall("wait", "{}")}})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	started := make(chan struct{})
+	eng.RegisterTool("wait", "Wait until cancelled", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}, true)
+	c := startPeer(t, newACPAgent().peer)
+
+	c.send(newSessionRequest(t, 1, "[]"))
+	sessionID := c.response(1)["result"].(map[string]any)["sessionId"].(string)
+	c.send(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"sessionId":"` + sessionID + `","prompt":[{"type":"text","text":"wait"}]}}`)
+	<-started
+	c.send(`{"jsonrpc":"2.0","method":"session/cancel","params":{"sessionId":"` + sessionID + `"}}`)
+	if res := c.response(2)["result"].(map[string]any); res["stopReason"] != "cancelled" {
+		t.Errorf("cancelled prompt = %v", res)
+	}
+}
+
+func TestACP_SessionMCPServers(t *testing.T) {
+	srv := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return newTestMCPServer() }, nil))
+	t.Cleanup(srv.Close)
+	withTestMCP(t)
+	agent := newACPAgent()
+	// Cleanups run in reverse, so the sessions' MCP clients are gone before
+	// the server closes; an open stream would block srv.Close.
+	t.Cleanup(agent.closeSessions)
+	c := startPeer(t, agent.peer)
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"session/new","params":{"cwd":"` + t.TempDir() + `","mcpServers":[]}}`)
+	if m := c.response(1); errorCode(m) != codeInvalidParams {
+		t.Errorf("session in another directory: %v", m)
+	}
+
+	// Sessions are created concurrently; the second one shares the server.
+	servers := `[{"type":"http","name":"calc","url":"` + srv.URL + `"}]`
+	c.send(newSessionRequest(t, 2, servers))
+	c.send(newSessionRequest(t, 3, servers))
+	// The responses may arrive in either order.
+	for pending := map[any]bool{2.0: true, 3.0: true}; len(pending) > 0; {
+		m := c.recv()
+		if !pending[m["id"]] {
+			continue
+		}
+		delete(pending, m["id"])
+		if m["error"] != nil {
+			t.Fatalf("session/new: %v", m)
+		}
+	}
+	if n := len(listMCPConnections()); n != 1 || !eng.HasTool("add") {
+		t.Fatalf("expected one connection with its tools, got %d connections", n)
+	}
+
+	agent.closeSessions()
+	if n := len(listMCPConnections()); n != 0 || eng.HasTool("add") {
+		t.Errorf("after the sessions ended: %d connections, add registered %v", n, eng.HasTool("add"))
+	}
+}
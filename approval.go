@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// toolApprover asks before running MCP tools that are not trusted in
+// mcp.json. On the terminal, plugin and built-in tools run without asking.
+// In STDIO mode the editor is asked about every tool that is not marked safe.
+type toolApprover struct {
+	mu     sync.Mutex
+	always map[string]bool
+}
+
+func newToolApprover() *toolApprover {
+	return &toolApprover{always: make(map[string]bool)}
+}
+
+func (a *toolApprover) Approve(ctx context.Context, toolName, args string) (bool, error) {
+	if editorPeer != nil && !stdioAutoApprove {
+		return a.approveInEditor(ctx, toolName, args)
+	}
+
+	src := getToolSource(toolName)
+	if skipApproval || autonomousMode || oneshotMode || src.Kind != "mcp" {
+		return true, nil
+	}
+
+	// Tools run concurrently; ask one question at a time.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.always[toolName] {
+		return true, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "\n[approval] %s wants to run %s(%s)\n", src, toolName, args)
+	response, err := readFromTTY("Allow? [y/N/a(lways)]: ")
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(strings.ToLower(response)) {
+	case "y", "yes":
+		return true, nil
+	case "a", "always":
+		a.always[toolName] = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (a *toolApprover) approveInEditor(ctx context.Context, toolName, args string) (bool, error) {
+	a.mu.Lock()
+	always := a.always[toolName]
+	a.mu.Unlock()
+	if always {
+		return true, nil
+	}
+
+	res, err := editorPeer.approveTool(ctx, toolName, args, getToolSource(toolName).String())
+	if errors.Is(err, errEditorUnavailable) {
+		// Line-delimited editors, and editors that did not declare
+		// tool_approval, have no way to answer.
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if res.Approved && res.Always {
+		a.mu.Lock()
+		a.always[toolName] = true
+		a.mu.Unlock()
+	}
+	return res.Approved, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// compareResult is one model's answer in a comparison.
+type compareResult struct {
+	name     string // provider/model
+	client   *openai.Client
+	model    string
+	content  string
+	messages []openai.ChatCompletionMessage
+	err      error
+	elapsed  time.Duration
+}
+
+func parseCompareModels(list string) ([]string, error) {
+	var names []string
+	for _, n := range strings.Split(list, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	if len(names) < 2 {
+		return nil, errors.New("compare needs at least two models separated by commas")
+	}
+	return names, nil
+}
+
+// compareModels runs the conversation against every model concurrently.
+// Tools are not offered, so their side effects do not happen once per model.
+func compareModels(ctx context.Context, messages []openai.ChatCompletionMessage, names []string, skill string) []compareResult {
+	results := make([]compareResult, len(names))
+	var wg sync.WaitGroup
+	for i, n := range names {
+		r := &results[i]
+		r.name = n
+		r.client, r.model, r.err = newModelClient(n)
+		if r.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			history := append([]openai.ChatCompletionMessage(nil), messages...)
+			r.content, r.messages, r.err = eng.Chat(ctx, history, engine.ChatOptions{
+				Skill:  skill,
+				Client: r.client,
+				Model:  r.model,
+				Tools:  []string{},
+				Vision: supportsVision(r.name),
+			})
+			r.elapsed = time.Since(start)
+			if !quiet {
+				fmt.Fprintf(stderr, "\x1b[2m[%s finished in %.1fs]\x1b[0m\n", r.name, r.elapsed.Seconds())
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func printCompareResults(results []compareResult) {
+	for i, r := range results {
+		if r.err != nil {
+			fmt.Printf("=== [%d] %s ===\nError: %v\n\n", i+1, r.name, r.err)
+			continue
+		}
+		fmt.Printf("=== [%d] %s (%.1fs) ===\n%s\n\n", i+1, r.name, r.elapsed.Seconds(), strings.TrimSpace(r.content))
+	}
+}
+
+// runCompareMode answers the one-shot prompt with each model of list.
+func runCompareMode(list, skill string) error {
+	names, err := parseCompareModels(list)
+	if err != nil {
+		return err
+	}
+	prompt := readOneshotInput()
+	if prompt == "" {
+		return errors.New("-compare needs a prompt")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results := compareModels(ctx, engine.UserMessage(attachMCPResources(ctx, prompt)), names, skill)
+	printCompareResults(results)
+	for _, r := range results {
+		if r.err == nil {
+			return nil
+		}
+	}
+	return errors.New("every model failed")
+}
+
+// compareCommand handles /compare: it asks each model the prompt in the
+// current conversation and lets the user continue with one of the answers,
+// switching to that model.
+func compareCommand(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
+	list, prompt, _ := strings.Cut(args, " ")
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		fmt.Fprintln(os.Stderr, "Usage: /compare provider/model,provider/model <prompt>")
+		return
+	}
+	names, err := parseCompareModels(list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chatMu.Lock()
+	chatCancel = cancel
+	chatMu.Unlock()
+	defer func() {
+		chatMu.Lock()
+		chatCancel = nil
+		chatMu.Unlock()
+		cancel()
+	}()
+
+	history := append(append([]openai.ChatCompletionMessage(nil), *messages...), openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: attachMCPResources(ctx, prompt),
+	})
+	results := compareModels(ctx, history, names, skill)
+	printCompareResults(results)
+	if ctx.Err() != nil {
+		return
+	}
+
+	response, err := readFromTTY(fmt.Sprintf("Continue with which answer? [1-%d, Enter to discard]: ", len(results)))
+	if err != nil {
+		return
+	}
+	response = strings.TrimSpace(response)
+	if response == "" {
+		fmt.Println("Comparison discarded.")
+		return
+	}
+	n, err := strconv.Atoi(response)
+	if err != nil || n < 1 || n > len(results) || results[n-1].err != nil {
+		fmt.Fprintf(os.Stderr, "Error: no answer %s to continue with. Comparison discarded.\n", response)
+		return
+	}
+
+	r := results[n-1]
+	providerName, _, _ := strings.Cut(r.name, "/")
+	*messages = r.messages
+	*client = r.client
+	setActiveModel(findProvider(providerName), r.client, r.model)
+	fmt.Printf("Continuing with %s\n", r.name)
+
+	if workDir, _ := os.Getwd(); configDir != "" && workDir != "" {
+		if err := saveSession(configDir, workDir, *messages); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save session: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+func TestCompareModels(t *testing.T) {
+	llmA := newFakeLLM(t, fakeTurn{Content: "from a"})
+	llmB := newFakeLLM(t, fakeTurn{Content: "from b"})
+	eng = engine.New(engine.Config{Client: llmA.client(), Model: "test"})
+	eng.RegisterTool("write", "write", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return "", nil
+	}, true)
+	savedProviders := providers
+	providers = []Provider{{Name: "a", APIURL: llmA.srv.URL + "/v1"}, {Name: "b", APIURL: llmB.srv.URL + "/v1"}}
+	defer func() { providers = savedProviders }()
+
+	results := compareModels(context.Background(), engine.UserMessage("question"), []string{"a/small", "b/large", "c/missing"}, "")
+	if len(results) != 3 {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, want := range []string{"from a", "from b"} {
+		r := results[i]
+		if r.err != nil || r.content != want || len(r.messages) != 2 {
+			t.Errorf("result %d = %q, %v, %d messages", i, r.content, r.err, len(r.messages))
+		}
+	}
+	if results[2].err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+	for _, llm := range []*fakeLLM{llmA, llmB} {
+		reqs := llm.Requests()
+		if len(reqs) != 1 || reqs[0].Messages[0].Content != "question" || len(reqs[0].Tools) != 0 {
+			t.Errorf("requests = %+v, want the question without tools", reqs)
+		}
+	}
+	if reqs := llmB.Requests(); reqs[0].Model != "large" {
+		t.Errorf("model = %q", reqs[0].Model)
+	}
+}
+
+func TestParseCompareModels(t *testing.T) {
+	names, err := parseCompareModels(" openai/gpt-4.1, google/gemini-2.5-flash ,")
+	if err != nil || len(names) != 2 || names[1] != "google/gemini-2.5-flash" {
+		t.Errorf("parseCompareModels = %q, %v", names, err)
+	}
+	if _, err := parseCompareModels("openai/gpt-4.1"); err == nil {
+		t.Error("expected an error for a single model")
+	}
+}
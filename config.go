@@ -3,18 +3,46 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 )
 
+type PluginSettings struct {
+	Timeout   int            `json:"timeout,omitempty"`    // seconds
+	MaxOutput int            `json:"max_output,omitempty"` // bytes
+	Config    map[string]any `json:"config,omitempty"`     // read by hostapi.GetConfig
+}
+
 type Config struct {
 	Prompt       string `json:"prompt"`
 	IdentityFile string `json:"identity_file"`
+
+	PluginTimeout   int                       `json:"plugin_timeout,omitempty"`    // seconds
+	PluginMaxOutput int                       `json:"plugin_max_output,omitempty"` // bytes
+	Plugins         map[string]PluginSettings `json:"plugins,omitempty"`
+
+	// VisionModels lists models (as provider/model, glob patterns allowed)
+	// that accept images returned by tools.
+	VisionModels []string `json:"vision_models,omitempty"`
+
+	// Fallbacks maps models (as provider/model, glob patterns allowed) to
+	// the models tried in order when they fail.
+	Fallbacks map[string][]string `json:"fallbacks,omitempty"`
+	// Routing names the model for kinds of calls: "summary", "plan" and
+	// "tools".
+	Routing map[string]string `json:"routing,omitempty"`
 }
 
 var appConfig = Config{
 	Prompt: ">",
 }
 
+const (
+	defaultPluginTimeout   = 2 * time.Minute
+	defaultPluginMaxOutput = 50000
+)
+
 func loadConfig(configDir string) error {
 	path := filepath.Join(configDir, "config.json")
 	data, err := os.ReadFile(path)
@@ -26,3 +54,34 @@ func loadConfig(configDir string) error {
 	}
 	return json.Unmarshal(data, &appConfig)
 }
+
+// pluginLimits returns the timeout and maximum output size for a plugin tool.
+// Per-plugin settings take precedence over the global ones.
+func pluginLimits(name string) (time.Duration, int) {
+	timeout := defaultPluginTimeout
+	if appConfig.PluginTimeout > 0 {
+		timeout = time.Duration(appConfig.PluginTimeout) * time.Second
+	}
+	maxOutput := defaultPluginMaxOutput
+	if appConfig.PluginMaxOutput > 0 {
+		maxOutput = appConfig.PluginMaxOutput
+	}
+	if s, ok := appConfig.Plugins[name]; ok {
+		if s.Timeout > 0 {
+			timeout = time.Duration(s.Timeout) * time.Second
+		}
+		if s.MaxOutput > 0 {
+			maxOutput = s.MaxOutput
+		}
+	}
+	return timeout, maxOutput
+}
+
+func supportsVision(providerModel string) bool {
+	for _, pattern := range appConfig.VisionModels {
+		if ok, _ := path.Match(pattern, providerModel); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// delegateToolName is never offered to a sub-agent, so delegation does not
+// nest.
+const delegateToolName = "delegate"
+
+type delegateRequest struct {
+	Task  string    `json:"task"`
+	Tools *[]string `json:"tools"`
+	Model string    `json:"model"`
+	Skill string    `json:"skill"`
+}
+
+func setupDelegateTool() {
+	eng.RegisterTool(delegateToolName, "Hand a self-contained task to a sub-agent that works in its own fresh conversation and returns only its final answer. Use it for exploratory or tool-heavy work whose intermediate output you do not need to see.", json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"task": {
+				"type": "string",
+				"description": "The task, including all context the sub-agent needs; it cannot see this conversation"
+			},
+			"tools": {
+				"type": "array",
+				"items": {"type": "string"},
+				"description": "Names of the tools the sub-agent may use (default: all of your tools)"
+			},
+			"model": {
+				"type": "string",
+				"description": "Model for the sub-agent as provider/model (default: your model)"
+			},
+			"skill": {
+				"type": "string",
+				"description": "Skill for the sub-agent to use"
+			}
+		},
+		"required": ["task"]
+	}`), delegate, true)
+}
+
+// delegate runs the task as a separate autonomous chat, limited to the
+// engine's autonomous iteration limit. The sub-agent may only use tools the
+// calling chat may use, and runs on its model unless told otherwise. Its tool
+// calls are shown as progress of the delegate call.
+func delegate(ctx context.Context, args string) (string, error) {
+	var req delegateRequest
+	if err := json.Unmarshal([]byte(args), &req); err != nil {
+		return "", err
+	}
+	parent, _ := engine.CallerOptions(ctx)
+	allowed := func(name string) bool {
+		return parent.Tools == nil || slices.Contains(parent.Tools, name)
+	}
+
+	var tools []string
+	if req.Tools != nil {
+		if slices.Contains(*req.Tools, delegateToolName) {
+			return "", errors.New("a sub-agent cannot delegate")
+		}
+		for _, name := range *req.Tools {
+			if !allowed(name) {
+				return "", fmt.Errorf("tool %s is not available to this conversation", name)
+			}
+		}
+		tools = append([]string{}, *req.Tools...)
+	} else {
+		tools = []string{}
+		for _, t := range eng.Tools() {
+			if name := t.Function.Name; name != delegateToolName && allowed(name) {
+				tools = append(tools, name)
+			}
+		}
+	}
+
+	opts := engine.ChatOptions{
+		Autonomous: true,
+		OnToolCall: func(name, arguments string) {
+			engine.ReportProgress(ctx, fmt.Sprintf("[delegate] tool: %s(%s)\n", name, arguments))
+		},
+	}
+	options := RequestOptions{Model: req.Model, Skill: req.Skill, Tools: tools}
+	if err := options.apply(&opts); err != nil {
+		return "", plainError(err)
+	}
+	if req.Model == "" && parent.Client != nil {
+		opts.Client, opts.Model = parent.Client, parent.Model
+		opts.ModelName, opts.Vision = parent.ModelName, parent.Vision
+	}
+	if opts.Client == nil {
+		return "", errors.New("no model is configured for the sub-agent")
+	}
+
+	content, _, err := eng.Chat(ctx, engine.UserMessage(req.Task), opts)
+	if err != nil {
+		return "", err
+	}
+	if content == "" {
+		return "", errors.New("the sub-agent reached the iteration limit without an answer")
+	}
+	return content, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+func setupDelegateTest(t *testing.T, cfg engine.Config, turns ...fakeTurn) *fakeLLM {
+	t.Helper()
+	llm := newFakeLLM(t, turns...)
+	cfg.Client, cfg.Model = llm.client(), "test"
+	eng = engine.New(cfg)
+	setupDelegateTool()
+	for _, name := range []string{"read", "write"} {
+		eng.RegisterTool(name, name, json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+			return "contents", nil
+		}, true)
+	}
+	return llm
+}
+
+func TestDelegate(t *testing.T) {
+	llm := setupDelegateTest(t, engine.Config{},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall(delegateToolName, `{"task":"find it","tools":["read"]}`)}},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("read", "{}")}},
+		fakeTurn{Content: "the answer"},
+		fakeTurn{Content: "parent done"},
+	)
+
+	var progress strings.Builder
+	content, _, err := eng.Chat(context.Background(), engine.UserMessage("explore"), engine.ChatOptions{
+		OnToolProgress: func(name, text string) { progress.WriteString(name + ":" + text) },
+	})
+	if err != nil || content != "parent done" {
+		t.Fatalf("Chat = %q, %v", content, err)
+	}
+
+	reqs := llm.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("got %d model calls, want 4", len(reqs))
+	}
+	child := reqs[1]
+	if len(child.Messages) != 1 || child.Messages[0].Content != "find it" {
+		t.Errorf("child history = %+v, want only the task", child.Messages)
+	}
+	if len(child.Tools) != 1 || child.Tools[0].Function.Name != "read" {
+		t.Errorf("child tools = %+v, want only read", child.Tools)
+	}
+	parent := reqs[3].Messages
+	if last := parent[len(parent)-1]; last.Role != openai.ChatMessageRoleTool || last.Content != "the answer" {
+		t.Errorf("parent got %+v, want only the final answer", last)
+	}
+	if progress.String() != "delegate:[delegate] tool: read({})\n" {
+		t.Errorf("progress = %q", progress.String())
+	}
+}
+
+func TestDelegate_Errors(t *testing.T) {
+	llm := setupDelegateTest(t, engine.Config{MaxAutonomousIter: 1},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("read", "{}")}},
+	)
+	ctx := context.Background()
+
+	for _, args := range []string{
+		`{"task":"x","tools":["delegate"]}`,
+		`{"task":"x","tools":["missing"]}`,
+		`{"task":"x","model":"nope/x"}`,
+	} {
+		if result, isErr := eng.CallTool(ctx, delegateToolName, args); !isErr {
+			t.Errorf("%s: expected an error, got %q", args, result)
+		}
+	}
+	if len(llm.Requests()) != 0 {
+		t.Fatal("invalid delegations reached the model")
+	}
+
+	result, isErr := eng.CallTool(ctx, delegateToolName, `{"task":"loop"}`)
+	if !isErr || !strings.Contains(result, "iteration limit") {
+		t.Errorf("delegate past the iteration limit = %q", result)
+	}
+	for _, tool := range llm.Requests()[0].Tools {
+		if tool.Function.Name == delegateToolName {
+			t.Error("sub-agent was offered the delegate tool")
+		}
+	}
+}
+
+func TestDelegate_InheritsParentOptions(t *testing.T) {
+	engineLLM := setupDelegateTest(t, engine.Config{})
+	parentLLM := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall(delegateToolName, `{"task":"b","tools":["write"]}`)}},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall(delegateToolName, `{"task":"a"}`)}},
+		fakeTurn{Content: "child answer"},
+		fakeTurn{Content: "parent done"},
+	)
+
+	content, _, err := eng.Chat(context.Background(), engine.UserMessage("explore"), engine.ChatOptions{
+		Client: parentLLM.client(),
+		Model:  "parent-model",
+		Tools:  []string{delegateToolName, "read"},
+	})
+	if err != nil || content != "parent done" {
+		t.Fatalf("Chat = %q, %v", content, err)
+	}
+	if n := len(engineLLM.Requests()); n != 0 {
+		t.Errorf("sub-agent used the engine's model %d times instead of the parent's", n)
+	}
+
+	reqs := parentLLM.Requests()
+	if len(reqs) != 4 {
+		t.Fatalf("got %d model calls, want 4", len(reqs))
+	}
+	if msgs := reqs[1].Messages; !strings.Contains(msgs[len(msgs)-1].Content, "write is not available") {
+		t.Errorf("delegating a tool the parent lacks = %q", msgs[len(msgs)-1].Content)
+	}
+	child := reqs[2]
+	if child.Model != "parent-model" || child.Messages[0].Content != "a" {
+		t.Errorf("child request = model %q, messages %+v", child.Model, child.Messages)
+	}
+	if len(child.Tools) != 1 || child.Tools[0].Function.Name != "read" {
+		t.Errorf("child tools = %+v, want only read", child.Tools)
+	}
+}
+
+func TestDelegate_NoModel(t *testing.T) {
+	// As in `yagi tool run`, where no provider is set up.
+	eng = engine.New(engine.Config{})
+	setupDelegateTool()
+
+	result, isErr := eng.CallTool(context.Background(), delegateToolName, `{"task":"hi"}`)
+	if !isErr || !strings.Contains(result, "no model") {
+		t.Errorf("delegate without a model = %q", result)
+	}
+	if err := runToolCommand([]string{"run", delegateToolName, `{"task":"hi"}`}); err == nil {
+		t.Error("tool run delegate: expected an error")
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/google/jsonschema-go/jsonschema"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -20,10 +22,38 @@ type ToolApprover interface {
 	Approve(ctx context.Context, toolName, args string) (bool, error)
 }
 
+// ToolError is a failure of the tool machinery itself (a panic, a timeout,
+// invalid arguments) rather than an error returned by the tool. It is reported
+// to the model as a JSON object so the model can tell the two apart.
+type ToolError struct {
+	Kind    string `json:"error"`
+	Tool    string `json:"tool"`
+	Message string `json:"message"`
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
 type toolMetadata struct {
-	safe bool
+	safe   bool
+	schema *jsonschema.Resolved
 }
 
+// ModelChoice is a model the engine can call. Name identifies it to the
+// user and to Config.Fallbacks, e.g. as provider/model.
+type ModelChoice struct {
+	Name   string
+	Client *openai.Client
+	Model  string
+}
+
+// Kinds of calls that Config.Route can send to another model.
+const (
+	RouteSummary = "summary" // context compression summaries
+	RouteTools   = "tools"   // calls continuing after tool results
+)
+
 type Config struct {
 	Client *openai.Client
 	Model  string
@@ -31,6 +61,13 @@ type Config struct {
 	SystemMessage func(skill string) string
 	Approver      ToolApprover
 
+	// Fallbacks returns the models to try, in order, when the named model
+	// still fails after its retries.
+	Fallbacks func(name string) []ModelChoice
+	// Route returns the model for a kind of call, or false to keep the
+	// chat's model.
+	Route func(kind string) (ModelChoice, bool)
+
 	MaxRetries        int
 	MaxAutonomousIter int
 	CompressThreshold int
@@ -38,14 +75,138 @@ type Config struct {
 }
 
 type ChatOptions struct {
-	Skill        string
-	Autonomous   bool
-	OnContent    func(text string)
-	OnReasoning  func(text string)
-	OnToolCall   func(name, arguments string)
-	OnToolResult func(name, result string)
-	OnToolError  func(name, errMsg string)
-	OnCompressed func(oldChars int)
+	Skill          string
+	Autonomous     bool
+	OnContent      func(text string)
+	OnReasoning    func(text string)
+	OnToolCall     func(name, arguments string)
+	OnToolProgress func(name, text string)
+	OnToolResult   func(name, result string)
+	OnToolError    func(name, errMsg string)
+	OnCompressed   func(oldChars int)
+
+	// OnToolOutput is like OnToolResult but also carries the images and
+	// structured content attached by the tool.
+	OnToolOutput func(name string, output ToolOutput)
+
+	// Vision sends images attached by tools to the model as image parts of
+	// a user message following the tool results.
+	Vision bool
+
+	// OnUsage receives the token usage of each model call. Setting it asks
+	// the provider to include usage in the stream.
+	OnUsage func(usage openai.Usage)
+
+	// Per-call overrides of the engine's settings. Zero values keep the
+	// engine's client, model, system message and iteration limit, all tools
+	// and the provider's sampling defaults. Concurrent chats with different
+	// overrides do not affect each other.
+	Client        *openai.Client
+	Model         string
+	ModelName     string // name of the chat's model, for Fallbacks
+	SystemMessage string
+	Tools         []string // names of the tools offered to the model
+	Temperature   float32
+	TopP          float32
+	MaxTokens     int
+	MaxIterations int
+
+	// OnModel is called before a call goes to a model other than the
+	// chat's, because of a route or a fallback.
+	OnModel func(name, reason string)
+}
+
+// clientAndModel returns the client and model to use for a call.
+func (e *Engine) clientAndModel(opts ChatOptions) (*openai.Client, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	client, model := e.client, e.model
+	if opts.Client != nil {
+		client = opts.Client
+	}
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	return client, model
+}
+
+// allowsTool reports whether the call may use the named tool.
+func (opts ChatOptions) allowsTool(name string) bool {
+	if opts.Tools == nil {
+		return true
+	}
+	for _, t := range opts.Tools {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+type progressKey struct{}
+
+// ReportProgress sends incremental output of a running tool to the
+// OnToolProgress callback of the chat that invoked it. It is a no-op when the
+// tool was not invoked from Chat or no callback is set.
+func ReportProgress(ctx context.Context, text string) {
+	if fn, ok := ctx.Value(progressKey{}).(func(string)); ok {
+		fn(text)
+	}
+}
+
+type optionsKey struct{}
+
+// CallerOptions returns the options of the chat that invoked the running
+// tool, so a tool that starts a chat of its own can keep within them.
+func CallerOptions(ctx context.Context) (ChatOptions, bool) {
+	opts, ok := ctx.Value(optionsKey{}).(ChatOptions)
+	return opts, ok
+}
+
+type ToolImage struct {
+	MIMEType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// ToolOutput is the result of a tool call including the non-text content the
+// tool attached with AttachImage and AttachStructured.
+type ToolOutput struct {
+	Text       string
+	Images     []ToolImage
+	Structured any
+}
+
+type outputKey struct{}
+
+type outputCollector struct {
+	mu     sync.Mutex
+	vision bool
+	images []ToolImage
+	data   any
+}
+
+// AttachImage attaches an image to the result of the running tool. It reports
+// whether the image will be shown to the model; when it returns false the tool
+// should describe the image in its text output instead.
+func AttachImage(ctx context.Context, mimeType string, data []byte) bool {
+	c, ok := ctx.Value(outputKey{}).(*outputCollector)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.images = append(c.images, ToolImage{MIMEType: mimeType, Data: data})
+	return c.vision
+}
+
+// AttachStructured attaches structured content to the result of the running
+// tool. It is passed to OnToolOutput but not sent to the model.
+func AttachStructured(ctx context.Context, v any) {
+	if c, ok := ctx.Value(outputKey{}).(*outputCollector); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.data = v
+	}
 }
 
 type Engine struct {
@@ -59,6 +220,8 @@ type Engine struct {
 
 	systemMessage func(skill string) string
 	approver      ToolApprover
+	fallbacks     func(name string) []ModelChoice
+	route         func(kind string) (ModelChoice, bool)
 
 	maxRetries        int
 	maxAutonomousIter int
@@ -106,6 +269,8 @@ func New(cfg Config) *Engine {
 		},
 		systemMessage:     cfg.SystemMessage,
 		approver:          cfg.Approver,
+		fallbacks:         cfg.Fallbacks,
+		route:             cfg.Route,
 		maxRetries:        maxRetries,
 		maxAutonomousIter: maxAuto,
 		compressThreshold: compressThreshold,
@@ -113,18 +278,48 @@ func New(cfg Config) *Engine {
 	}
 }
 
+// RegisterTool adds a tool, replacing any tool already registered under the
+// same name. It is safe to call while a chat is running.
 func (e *Engine) RegisterTool(name, description string, parameters json.RawMessage, fn ToolFunc, safe bool) {
 	var params openai.FunctionDefinition
 	params.Name = name
 	params.Description = description
 	params.Parameters = parameters
 
+	// Schemas that cannot be compiled are still sent to the model as-is; only
+	// argument validation is skipped for them.
+	schema, _ := compileSchema(parameters)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removeToolLocked(name)
 	e.tools = append(e.tools, openai.Tool{
 		Type:     openai.ToolTypeFunction,
 		Function: &params,
 	})
 	e.toolFuncs[name] = fn
-	e.toolMeta[name] = toolMetadata{safe: safe}
+	e.toolMeta[name] = toolMetadata{safe: safe, schema: schema}
+}
+
+func (e *Engine) UnregisterTool(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removeToolLocked(name)
+}
+
+func (e *Engine) removeToolLocked(name string) {
+	if _, ok := e.toolFuncs[name]; !ok {
+		return
+	}
+	tools := make([]openai.Tool, 0, len(e.tools))
+	for _, t := range e.tools {
+		if t.Function.Name != name {
+			tools = append(tools, t)
+		}
+	}
+	e.tools = tools
+	delete(e.toolFuncs, name)
+	delete(e.toolMeta, name)
 }
 
 func (e *Engine) Client() *openai.Client {
@@ -145,6 +340,15 @@ func (e *Engine) SetModel(model string) {
 	e.model = model
 }
 
+// SetClientAndModel switches the default client and model together, so a
+// chat starting meanwhile never pairs the new client with the old model.
+func (e *Engine) SetClientAndModel(client *openai.Client, model string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.client = client
+	e.model = model
+}
+
 func (e *Engine) SetContextLimits(compressThreshold, maxContextChars int) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -158,11 +362,27 @@ func (e *Engine) Model() string {
 	return e.model
 }
 
+// ClientAndModel returns the default client and model as one consistent pair.
+func (e *Engine) ClientAndModel() (*openai.Client, string) {
+	return e.clientAndModel(ChatOptions{})
+}
+
+func (e *Engine) contextLimits() (compressThreshold, maxContextChars int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.compressThreshold, e.maxContextChars
+}
+
+// Tools returns a copy of the registered tools.
 func (e *Engine) Tools() []openai.Tool {
-	return e.tools
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]openai.Tool(nil), e.tools...)
 }
 
 func (e *Engine) HasTool(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	_, ok := e.toolFuncs[name]
 	return ok
 }
@@ -172,6 +392,12 @@ func (e *Engine) ExecuteTool(ctx context.Context, name, arguments string) string
 	return result
 }
 
+// CallTool is like ExecuteTool but also reports whether the result is an
+// error message.
+func (e *Engine) CallTool(ctx context.Context, name, arguments string) (result string, isError bool) {
+	return e.executeTool(ctx, name, arguments)
+}
+
 func (e *Engine) suggestAlternatives(name string) string {
 	alts, ok := e.toolAlts[name]
 	if !ok {
@@ -179,7 +405,7 @@ func (e *Engine) suggestAlternatives(name string) string {
 	}
 	var available []string
 	for _, alt := range alts {
-		if _, exists := e.toolFuncs[alt]; exists {
+		if e.HasTool(alt) {
 			available = append(available, alt)
 		}
 	}
@@ -190,12 +416,19 @@ func (e *Engine) suggestAlternatives(name string) string {
 }
 
 func (e *Engine) executeTool(ctx context.Context, name, arguments string) (string, bool) {
+	e.mu.Lock()
 	fn, ok := e.toolFuncs[name]
+	meta := e.toolMeta[name]
+	e.mu.Unlock()
 	if !ok {
 		return fmt.Sprintf("Unknown tool: %s", name), true
 	}
 
-	meta := e.toolMeta[name]
+	arguments, err := prepareArguments(meta.schema, arguments)
+	if err != nil {
+		return formatToolError(&ToolError{Kind: "invalid_arguments", Tool: name, Message: err.Error()}), true
+	}
+
 	if !meta.safe && e.approver != nil {
 		approved, err := e.approver.Approve(ctx, name, arguments)
 		if err != nil {
@@ -208,29 +441,62 @@ func (e *Engine) executeTool(ctx context.Context, name, arguments string) (strin
 
 	result, err := fn(ctx, arguments)
 	if err != nil {
+		var te *ToolError
+		if errors.As(err, &te) {
+			return formatToolError(te) + e.suggestAlternatives(name), true
+		}
 		return fmt.Sprintf("Error: %v%s", err, e.suggestAlternatives(name)), true
 	}
 	return result, false
 }
 
+func formatToolError(te *ToolError) string {
+	b, _ := json.Marshal(te)
+	return "Error: " + string(b)
+}
+
 type toolResult struct {
 	id      string
 	output  string
 	isError bool
+	images  []ToolImage
+	data    any
 }
 
-func (e *Engine) executeToolsConcurrently(ctx context.Context, toolCalls []openai.ToolCall) ([]openai.ChatCompletionMessage, []toolResult) {
+func (e *Engine) executeToolsConcurrently(ctx context.Context, toolCalls []openai.ToolCall, opts ChatOptions) ([]openai.ChatCompletionMessage, []toolResult) {
 	results := make([]toolResult, len(toolCalls))
 	var wg sync.WaitGroup
+	// Tools run concurrently; serialize progress so callers can write to the
+	// terminal or stdout without their own locking.
+	var progressMu sync.Mutex
 	for i, tc := range toolCalls {
 		wg.Add(1)
 		go func(i int, tc openai.ToolCall) {
 			defer wg.Done()
-			output, isErr := e.executeTool(ctx, tc.Function.Name, tc.Function.Arguments)
+			collector := &outputCollector{vision: opts.Vision}
+			toolCtx := context.WithValue(ctx, outputKey{}, collector)
+			toolCtx = context.WithValue(toolCtx, optionsKey{}, opts)
+			if opts.OnToolProgress != nil {
+				name := tc.Function.Name
+				toolCtx = context.WithValue(toolCtx, progressKey{}, func(text string) {
+					progressMu.Lock()
+					defer progressMu.Unlock()
+					opts.OnToolProgress(name, text)
+				})
+			}
+			var output string
+			var isErr bool
+			if opts.allowsTool(tc.Function.Name) {
+				output, isErr = e.executeTool(toolCtx, tc.Function.Name, tc.Function.Arguments)
+			} else {
+				output, isErr = fmt.Sprintf("Unknown tool: %s", tc.Function.Name), true
+			}
 			results[i] = toolResult{
 				id:      tc.ID,
 				output:  output,
 				isError: isErr,
+				images:  collector.images,
+				data:    collector.data,
 			}
 		}(i, tc)
 	}
@@ -244,9 +510,41 @@ func (e *Engine) executeToolsConcurrently(ctx context.Context, toolCalls []opena
 			ToolCallID: r.id,
 		}
 	}
+	if opts.Vision {
+		if msg, ok := imageMessage(toolCalls, results); ok {
+			msgs = append(msgs, msg)
+		}
+	}
 	return msgs, results
 }
 
+// imageMessage builds a user message carrying the images attached by tools,
+// since tool messages themselves can only hold text.
+func imageMessage(toolCalls []openai.ToolCall, results []toolResult) (openai.ChatCompletionMessage, bool) {
+	var parts []openai.ChatMessagePart
+	for i, r := range results {
+		if len(r.images) == 0 {
+			continue
+		}
+		parts = append(parts, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeText,
+			Text: fmt.Sprintf("Images returned by %s:", toolCalls[i].Function.Name),
+		})
+		for _, img := range r.images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL: "data:" + img.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(img.Data),
+				},
+			})
+		}
+	}
+	if len(parts) == 0 {
+		return openai.ChatCompletionMessage{}, false
+	}
+	return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: parts}, true
+}
+
 func (e *Engine) processStreamResponse(stream *openai.ChatCompletionStream, opts ChatOptions) (string, []openai.ToolCall, error) {
 	var fullContent strings.Builder
 	toolCallsMap := make(map[int]*openai.ToolCall)
@@ -261,6 +559,9 @@ func (e *Engine) processStreamResponse(stream *openai.ChatCompletionStream, opts
 			return "", nil, err
 		}
 
+		if resp.Usage != nil && opts.OnUsage != nil {
+			opts.OnUsage(*resp.Usage)
+		}
 		if len(resp.Choices) == 0 {
 			continue
 		}
@@ -320,8 +621,8 @@ func (e *Engine) processStreamResponse(stream *openai.ChatCompletionStream, opts
 }
 
 func (e *Engine) chat(ctx context.Context, messages []openai.ChatCompletionMessage, opts ChatOptions) (string, []openai.ToolCall, error) {
-	systemMsg := ""
-	if e.systemMessage != nil {
+	systemMsg := opts.SystemMessage
+	if systemMsg == "" && e.systemMessage != nil {
 		systemMsg = e.systemMessage(opts.Skill)
 	}
 	if systemMsg != "" && (len(messages) == 0 || messages[0].Role != openai.ChatMessageRoleSystem) {
@@ -332,6 +633,55 @@ func (e *Engine) chat(ctx context.Context, messages []openai.ChatCompletionMessa
 		messages = append([]openai.ChatCompletionMessage{systemMsgObj}, messages...)
 	}
 
+	client, model := e.clientAndModel(opts)
+	choice, reason := ModelChoice{Name: opts.ModelName, Client: client, Model: model}, ""
+	if len(messages) > 0 && messages[len(messages)-1].Role == openai.ChatMessageRoleTool {
+		if c, ok := e.routeFor(RouteTools); ok {
+			choice, reason = c, "route: "+RouteTools
+		}
+	}
+
+	candidates := []ModelChoice{choice}
+	if e.fallbacks != nil && choice.Name != "" {
+		candidates = append(candidates, e.fallbacks(choice.Name)...)
+	}
+	var err error
+	for i, c := range candidates {
+		if i > 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			reason = fmt.Sprintf("fallback: %s failed: %v", modelLabel(candidates[i-1]), err)
+		}
+		if reason != "" && opts.OnModel != nil {
+			opts.OnModel(modelLabel(c), reason)
+		}
+		var content string
+		var toolCalls []openai.ToolCall
+		content, toolCalls, err = e.chatWith(ctx, messages, opts, c)
+		if err == nil {
+			return content, toolCalls, nil
+		}
+	}
+	return "", nil, err
+}
+
+func (e *Engine) routeFor(kind string) (ModelChoice, bool) {
+	if e.route == nil {
+		return ModelChoice{}, false
+	}
+	return e.route(kind)
+}
+
+func modelLabel(c ModelChoice) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Model
+}
+
+// chatWith makes one call to the model, retrying on errors.
+func (e *Engine) chatWith(ctx context.Context, messages []openai.ChatCompletionMessage, opts ChatOptions, choice ModelChoice) (string, []openai.ToolCall, error) {
 	var lastErr error
 	for attempt := 0; attempt <= e.maxRetries; attempt++ {
 		if attempt > 0 {
@@ -346,18 +696,27 @@ func (e *Engine) chat(ctx context.Context, messages []openai.ChatCompletionMessa
 			}
 		}
 
+		var tools []openai.Tool
 		e.mu.Lock()
-		currentModel := e.model
+		for _, t := range e.tools {
+			if opts.allowsTool(t.Function.Name) {
+				tools = append(tools, t)
+			}
+		}
 		e.mu.Unlock()
 
-		stream, err := e.client.CreateChatCompletionStream(
-			ctx,
-			openai.ChatCompletionRequest{
-				Model:    currentModel,
-				Messages: messages,
-				Tools:    e.tools,
-			},
-		)
+		req := openai.ChatCompletionRequest{
+			Model:       choice.Model,
+			Messages:    messages,
+			Tools:       tools,
+			Temperature: opts.Temperature,
+			TopP:        opts.TopP,
+			MaxTokens:   opts.MaxTokens,
+		}
+		if opts.OnUsage != nil {
+			req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+		}
+		stream, err := choice.Client.CreateChatCompletionStream(ctx, req)
 		if err != nil {
 			lastErr = err
 			continue
@@ -379,11 +738,25 @@ func (e *Engine) chat(ctx context.Context, messages []openai.ChatCompletionMessa
 // Chat runs the full chat loop: sends messages, executes tool calls, and returns when the assistant
 // produces a final text response or the iteration limit is reached.
 func (e *Engine) Chat(ctx context.Context, messages []openai.ChatCompletionMessage, opts ChatOptions) (string, []openai.ChatCompletionMessage, error) {
+	maxIter := e.maxAutonomousIter
+	if opts.MaxIterations > 0 {
+		maxIter = opts.MaxIterations
+	}
+	if onModel := opts.OnModel; onModel != nil {
+		// Routed turns would repeat the same notice on every iteration.
+		var last string
+		opts.OnModel = func(name, reason string) {
+			if name+reason != last {
+				last = name + reason
+				onModel(name, reason)
+			}
+		}
+	}
 	iteration := 0
 
 	for {
 		iteration++
-		if opts.Autonomous && iteration > e.maxAutonomousIter {
+		if opts.Autonomous && iteration > maxIter {
 			break
 		}
 
@@ -405,13 +778,16 @@ func (e *Engine) Chat(ctx context.Context, messages []openai.ChatCompletionMessa
 				}
 			}
 
-			toolMsgs, toolResults := e.executeToolsConcurrently(ctx, toolCalls)
-			for i, msg := range toolMsgs {
-				if opts.OnToolError != nil && toolResults[i].isError {
-					opts.OnToolError(msg.ToolCallID, msg.Content)
+			toolMsgs, toolResults := e.executeToolsConcurrently(ctx, toolCalls, opts)
+			for i, r := range toolResults {
+				if opts.OnToolError != nil && r.isError {
+					opts.OnToolError(toolCalls[i].Function.Name, r.output)
+				}
+				if opts.OnToolResult != nil && !r.isError {
+					opts.OnToolResult(toolCalls[i].Function.Name, r.output)
 				}
-				if opts.OnToolResult != nil && !toolResults[i].isError {
-					opts.OnToolResult(toolCalls[i].Function.Name, msg.Content)
+				if opts.OnToolOutput != nil && !r.isError {
+					opts.OnToolOutput(toolCalls[i].Function.Name, ToolOutput{Text: r.output, Images: r.images, Structured: r.data})
 				}
 			}
 			messages = append(messages, toolMsgs...)
@@ -440,8 +816,9 @@ func (e *Engine) estimateChars(msgs []openai.ChatCompletionMessage) int {
 }
 
 func (e *Engine) compressContext(ctx context.Context, messages []openai.ChatCompletionMessage, opts ChatOptions) []openai.ChatCompletionMessage {
+	compressThreshold, maxContextChars := e.contextLimits()
 	chars := e.estimateChars(messages)
-	if chars < e.compressThreshold {
+	if chars < compressThreshold {
 		return messages
 	}
 
@@ -458,7 +835,7 @@ func (e *Engine) compressContext(ctx context.Context, messages []openai.ChatComp
 
 	end := start
 	kept := e.estimateChars(messages[start:])
-	for end < len(messages)-2 && kept > e.maxContextChars/2 {
+	for end < len(messages)-2 && kept > maxContextChars/2 {
 		kept -= utf8.RuneCountInString(messages[end].Content)
 		for _, tc := range messages[end].ToolCalls {
 			kept -= utf8.RuneCountInString(tc.Function.Arguments)
@@ -478,7 +855,7 @@ func (e *Engine) compressContext(ctx context.Context, messages []openai.ChatComp
 	}
 
 	oldMsgs := messages[start:end]
-	summary := e.summarizeMessages(ctx, oldMsgs)
+	summary := e.summarizeMessages(ctx, oldMsgs, opts)
 	if summary == "" {
 		return messages
 	}
@@ -501,7 +878,7 @@ func (e *Engine) compressContext(ctx context.Context, messages []openai.ChatComp
 	return result
 }
 
-func (e *Engine) summarizeMessages(ctx context.Context, msgs []openai.ChatCompletionMessage) string {
+func (e *Engine) summarizeMessages(ctx context.Context, msgs []openai.ChatCompletionMessage, opts ChatOptions) string {
 	var sb strings.Builder
 	for _, m := range msgs {
 		switch m.Role {
@@ -542,12 +919,17 @@ func (e *Engine) summarizeMessages(ctx context.Context, msgs []openai.ChatComple
 		},
 	}
 
-	e.mu.Lock()
-	currentModel := e.model
-	e.mu.Unlock()
+	client, model := e.clientAndModel(opts)
+	choice := ModelChoice{Name: opts.ModelName, Client: client, Model: model}
+	if c, ok := e.routeFor(RouteSummary); ok {
+		if opts.OnModel != nil {
+			opts.OnModel(modelLabel(c), "route: "+RouteSummary)
+		}
+		choice = c
+	}
 
-	stream, err := e.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model:    currentModel,
+	stream, err := choice.Client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:    choice.Model,
 		Messages: summaryMsgs,
 	})
 	if err != nil {
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+// ValidateSchema reports whether parameters is usable as a function calling
+// schema: valid JSON Schema whose top-level type is "object".
+func ValidateSchema(parameters json.RawMessage) error {
+	_, err := compileSchema(parameters)
+	return err
+}
+
+func compileSchema(parameters json.RawMessage) (*jsonschema.Resolved, error) {
+	if len(bytes.TrimSpace(parameters)) == 0 {
+		return nil, fmt.Errorf("schema is empty")
+	}
+	var s jsonschema.Schema
+	if err := json.Unmarshal(parameters, &s); err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	if s.Type != "object" {
+		return nil, fmt.Errorf(`top-level "type" must be "object", got %q`, s.Type)
+	}
+	resolved, err := s.Resolve(nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %w", err)
+	}
+	return resolved, nil
+}
+
+// prepareArguments repairs common JSON mistakes in model-generated arguments
+// and validates them against the tool's schema. It returns the arguments that
+// should be passed to the tool.
+func prepareArguments(schema *jsonschema.Resolved, arguments string) (string, error) {
+	if !json.Valid([]byte(arguments)) {
+		repaired := repairJSON(arguments)
+		if !json.Valid([]byte(repaired)) {
+			var v any
+			err := json.Unmarshal([]byte(arguments), &v)
+			return "", fmt.Errorf("arguments are not valid JSON: %v", err)
+		}
+		arguments = repaired
+	}
+	if schema == nil {
+		return arguments, nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(arguments), &v); err != nil {
+		return "", fmt.Errorf("arguments are not valid JSON: %v", err)
+	}
+	if err := schema.Validate(v); err != nil {
+		return "", fmt.Errorf("arguments do not match the parameters schema: %v", err)
+	}
+	return arguments, nil
+}
+
+// repairJSON fixes mistakes models commonly make when emitting JSON: empty
+// output, surrounding markdown code fences, and trailing commas.
+func repairJSON(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "{}"
+	}
+	if strings.HasPrefix(s, "```") {
+		s = strings.TrimPrefix(s, "```")
+		s = strings.TrimPrefix(s, "json")
+		s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+		s = strings.TrimSpace(s)
+	}
+
+	var b strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			b.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+		}
+		if c == ',' {
+			j := i + 1
+			for j < len(s) && strings.IndexByte(" \t\r\n", s[j]) >= 0 {
+				j++
+			}
+			if j < len(s) && (s[j] == '}' || s[j] == ']') {
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
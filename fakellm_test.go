@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// fakeTurn is one scripted assistant response of a fakeLLM.
+type fakeTurn struct {
+	Content   string
+	ToolCalls []openai.ToolCall
+}
+
+// fakeLLM is an OpenAI-compatible streaming endpoint that replays scripted
+// turns and records the requests it receives.
+type fakeLLM struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	turns    []fakeTurn
+	requests []openai.ChatCompletionRequest
+}
+
+func newFakeLLM(t *testing.T, turns ...fakeTurn) *fakeLLM {
+	t.Helper()
+	f := &fakeLLM{turns: turns}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeLLM) client() *openai.Client {
+	config := openai.DefaultConfig("test")
+	config.BaseURL = f.srv.URL + "/v1"
+	return openai.NewClientWithConfig(config)
+}
+
+func (f *fakeLLM) Requests() []openai.ChatCompletionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), f.requests...)
+}
+
+func (f *fakeLLM) handle(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	turn := fakeTurn{Content: "done"}
+	if len(f.turns) > 0 {
+		turn = f.turns[0]
+		f.turns = f.turns[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(delta openai.ChatCompletionStreamChoiceDelta, finish openai.FinishReason) {
+		chunk := openai.ChatCompletionStreamResponse{
+			ID:     "chatcmpl-test",
+			Object: "chat.completion.chunk",
+			Model:  req.Model,
+			Choices: []openai.ChatCompletionStreamChoice{
+				{Index: 0, Delta: delta, FinishReason: finish},
+			},
+		}
+		b, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+
+	if len(turn.ToolCalls) > 0 {
+		calls := make([]openai.ToolCall, len(turn.ToolCalls))
+		for i, tc := range turn.ToolCalls {
+			idx := i
+			tc.Index = &idx
+			if tc.Type == "" {
+				tc.Type = openai.ToolTypeFunction
+			}
+			if tc.ID == "" {
+				tc.ID = fmt.Sprintf("call_%d", i)
+			}
+			calls[i] = tc
+		}
+		send(openai.ChatCompletionStreamChoiceDelta{ToolCalls: calls}, openai.FinishReasonToolCalls)
+	} else {
+		send(openai.ChatCompletionStreamChoiceDelta{Content: turn.Content}, openai.FinishReasonStop)
+	}
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		b, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:     "chatcmpl-test",
+			Object: "chat.completion.chunk",
+			Model:  req.Model,
+			Usage:  &openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func toolCall(name, arguments string) openai.ToolCall {
+	return openai.ToolCall{Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -179,3 +184,227 @@ func webSocketSend(ctx context.Context, url string, message string, maxMessages
 	}
 	return string(b), nil
 }
+
+type pluginNameKey struct{}
+
+// withPluginName tags the context passed to a plugin so that host functions can
+// scope their behavior (config, logging) to the calling plugin.
+func withPluginName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, pluginNameKey{}, name)
+}
+
+func pluginNameFrom(ctx context.Context) string {
+	name, _ := ctx.Value(pluginNameKey{}).(string)
+	return name
+}
+
+type HTTPResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       string
+}
+
+func httpRequest(ctx context.Context, method, url string, headers map[string]string, body string) (*HTTPResponse, error) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), url, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	respHeaders := make(map[string]string, len(resp.Header))
+	for k := range resp.Header {
+		respHeaders[k] = resp.Header.Get(k)
+	}
+	return &HTTPResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    respHeaders,
+		Body:       string(b),
+	}, nil
+}
+
+func sandboxRoot() (string, error) {
+	root := pluginWorkDir
+	if root == "" {
+		var err error
+		if root, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	return filepath.Abs(root)
+}
+
+// resolveSandboxPath resolves path relative to the working directory and
+// rejects anything that ends up outside of it, including via symlinks.
+func resolveSandboxPath(path string) (string, error) {
+	root, err := sandboxRoot()
+	if err != nil {
+		return "", err
+	}
+	p := path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+
+	// Resolve symlinks on the longest existing prefix so that files which do
+	// not exist yet (WriteFile) are checked too.
+	existing, rest := p, ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			p = filepath.Join(resolved, rest)
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside the working directory", path)
+	}
+	return p, nil
+}
+
+func sandboxReadFile(ctx context.Context, path string) (string, error) {
+	p, err := resolveSandboxPath(path)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func sandboxWriteFile(ctx context.Context, path, content string) error {
+	p, err := resolveSandboxPath(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, []byte(content), 0o644)
+}
+
+func sandboxGlob(ctx context.Context, pattern string) ([]string, error) {
+	root, err := sandboxRoot()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := resolveSandboxPath(filepath.Dir(pattern)); err != nil {
+		return nil, err
+	}
+	p := pattern
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, m := range matches {
+		if _, err := resolveSandboxPath(m); err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, m); err == nil && !filepath.IsAbs(pattern) {
+			m = rel
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+type ExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// hostExec runs a command without a shell in the working directory, killing it
+// when timeoutSec elapses. A non-zero exit status is reported in ExitCode
+// rather than as an error.
+func hostExec(ctx context.Context, command string, args []string, timeoutSec int) (*ExecResult, error) {
+	if timeoutSec <= 0 {
+		timeoutSec = 60
+	}
+	root, err := sandboxRoot()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = root
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("command timed out after %ds", timeoutSec)
+	}
+	result := &ExecResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, nil
+}
+
+// getPluginConfig returns a setting from the calling plugin's "config" section
+// in config.json. Non-string values are returned JSON-encoded.
+func getPluginConfig(ctx context.Context, key string) (string, error) {
+	s, ok := appConfig.Plugins[pluginNameFrom(ctx)]
+	if !ok {
+		return "", nil
+	}
+	v, ok := s.Config[key]
+	if !ok {
+		return "", nil
+	}
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func pluginLog(ctx context.Context, message string) {
+	if !verbose {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[plugin %s] %s\n", pluginNameFrom(ctx), message)
+}
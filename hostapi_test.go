@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
+	"github.com/yagi-agent/yagi/engine"
+
 	"golang.org/x/net/html"
 )
 
@@ -86,3 +95,141 @@ func TestExtractText_BrTag(t *testing.T) {
 		t.Errorf("expected newline between Line1 and Line2, got %q", between)
 	}
 }
+
+func setSandbox(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved := pluginWorkDir
+	pluginWorkDir = dir
+	t.Cleanup(func() { pluginWorkDir = saved })
+	return dir
+}
+
+func TestResolveSandboxPath(t *testing.T) {
+	dir := setSandbox(t)
+	root, _ := filepath.EvalSymlinks(dir)
+
+	got, err := resolveSandboxPath("sub/file.txt")
+	if err != nil {
+		t.Fatalf("resolveSandboxPath: %v", err)
+	}
+	if got != filepath.Join(root, "sub", "file.txt") {
+		t.Errorf("got %q, want %q", got, filepath.Join(root, "sub", "file.txt"))
+	}
+
+	for _, p := range []string{"../outside.txt", "/etc/passwd", "sub/../../x"} {
+		if _, err := resolveSandboxPath(p); err == nil {
+			t.Errorf("resolveSandboxPath(%q): expected error", p)
+		}
+	}
+}
+
+func TestResolveSandboxPath_Symlink(t *testing.T) {
+	dir := setSandbox(t)
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlink not supported: %v", err)
+	}
+	if _, err := resolveSandboxPath("link/secret.txt"); err == nil {
+		t.Error("expected symlink escaping the sandbox to be rejected")
+	}
+}
+
+func TestSandboxReadWriteGlob(t *testing.T) {
+	setSandbox(t)
+	ctx := context.Background()
+
+	if err := sandboxWriteFile(ctx, "a/one.txt", "1"); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := sandboxWriteFile(ctx, "a/two.txt", "2"); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	got, err := sandboxReadFile(ctx, "a/one.txt")
+	if err != nil || got != "1" {
+		t.Errorf("ReadFile = %q, %v", got, err)
+	}
+	matches, err := sandboxGlob(ctx, "a/*.txt")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	want := []string{filepath.Join("a", "one.txt"), filepath.Join("a", "two.txt")}
+	if strings.Join(matches, ",") != strings.Join(want, ",") {
+		t.Errorf("Glob = %v, want %v", matches, want)
+	}
+	if err := sandboxWriteFile(ctx, "../escape.txt", "x"); err == nil {
+		t.Error("expected WriteFile outside the sandbox to fail")
+	}
+	if _, err := sandboxGlob(ctx, "../*"); err == nil {
+		t.Error("expected Glob outside the sandbox to fail")
+	}
+}
+
+func TestHTTPRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Header.Get("X-Token") + ":" + string(body)))
+	}))
+	defer srv.Close()
+
+	resp, err := httpRequest(context.Background(), "post", srv.URL, map[string]string{"X-Token": "t"}, "payload")
+	if err != nil {
+		t.Fatalf("httpRequest: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if resp.Headers["X-Method"] != "POST" {
+		t.Errorf("X-Method = %q, want POST", resp.Headers["X-Method"])
+	}
+	if resp.Body != "t:payload" {
+		t.Errorf("Body = %q, want %q", resp.Body, "t:payload")
+	}
+}
+
+func TestHostExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	setSandbox(t)
+
+	res, err := hostExec(context.Background(), "sh", []string{"-c", "pwd; echo err >&2; exit 3"}, 5)
+	if err != nil {
+		t.Fatalf("hostExec: %v", err)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want 3", res.ExitCode)
+	}
+	if strings.TrimSpace(res.Stderr) != "err" {
+		t.Errorf("Stderr = %q", res.Stderr)
+	}
+	root, _ := sandboxRoot()
+	if strings.TrimSpace(res.Stdout) != root {
+		t.Errorf("Stdout = %q, want working directory %q", res.Stdout, root)
+	}
+
+	if _, err := hostExec(context.Background(), "sleep", []string{"5"}, 1); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestGetPluginConfig_FromPlugin(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig.Plugins = map[string]PluginSettings{
+		"configured": {Config: map[string]any{"endpoint": "https://example.com", "retries": 3}},
+	}
+
+	eng = engine.New(engine.Config{})
+	loadInlinePlugin(t, "configured", "\t\"hostapi\"\n", `a, _ := hostapi.GetConfig(ctx, "endpoint")
+		b, _ := hostapi.GetConfig(ctx, "retries")
+		c, _ := hostapi.GetConfig(ctx, "missing")
+		return a + "|" + b + "|" + c, nil`)
+
+	got := eng.ExecuteTool(context.Background(), "configured", "{}")
+	if got != "https://example.com|3|" {
+		t.Errorf("got %q", got)
+	}
+}
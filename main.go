@@ -20,6 +20,7 @@ import (
 	"github.com/mattn/go-colorable"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/yagi-agent/yagi/engine"
+	"github.com/yagi-agent/yagi/provider"
 )
 
 //go:embed models.json
@@ -47,12 +48,58 @@ func findModelInfo(name string) *ModelInfo {
 	return nil
 }
 
+// currentModelName returns the active model as provider/model.
+func currentModelName() string {
+	p, _, modelName := activeModel()
+	return qualifiedModelName(p, modelName)
+}
+
+func qualifiedModelName(p *Provider, modelName string) string {
+	if p == nil {
+		return modelName
+	}
+	return p.Name + "/" + modelName
+}
+
+// activeModel returns the provider, client and model that chats use unless
+// they pick their own.
+func activeModel() (*Provider, *openai.Client, string) {
+	modelMu.Lock()
+	defer modelMu.Unlock()
+	client, modelName := eng.ClientAndModel()
+	return selectedProvider, client, modelName
+}
+
+// setActiveModel switches the default model. Chats already running keep the
+// model they started with when they pinned it in their ChatOptions.
+func setActiveModel(p *Provider, client *openai.Client, modelName string) {
+	modelMu.Lock()
+	defer modelMu.Unlock()
+	selectedProvider = p
+	eng.SetClientAndModel(client, modelName)
+	if mi := findModelInfo(p.Name + "/" + modelName); mi != nil && mi.MaxContextChars > 0 {
+		eng.SetContextLimits(mi.MaxContextChars*8/10, mi.MaxContextChars)
+	}
+}
+
+// pinActiveModel makes a chat use the active model until it ends, even if
+// the default is switched meanwhile.
+func pinActiveModel(opts *engine.ChatOptions) {
+	p, client, modelName := activeModel()
+	opts.Client, opts.Model = client, modelName
+	opts.ModelName = qualifiedModelName(p, modelName)
+	opts.Vision = supportsVision(opts.ModelName)
+}
+
 var (
+	// selectedProvider is guarded by modelMu; use activeModel and
+	// setActiveModel.
+	modelMu          sync.Mutex
 	selectedProvider *Provider
-	model            string
-	quiet            bool
-	verbose          bool
-	oneshotMode      bool
+
+	quiet       bool
+	verbose     bool
+	oneshotMode bool
 
 	chatMu     sync.Mutex
 	chatCancel context.CancelFunc
@@ -61,6 +108,9 @@ var (
 	autonomousMode bool
 	planningMode   bool
 
+	// headless is set in modes with no terminal to ask the user on.
+	headless bool
+
 	eng *engine.Engine
 )
 
@@ -92,10 +142,7 @@ func setupBuiltInTools() {
 		case "version":
 			return fmt.Sprintf("yagi version %s (revision: %s/%s)", version, revision, runtime.Version()), nil
 		case "model":
-			if selectedProvider != nil {
-				return fmt.Sprintf("%s/%s", selectedProvider.Name, eng.Model()), nil
-			}
-			return eng.Model(), nil
+			return currentModelName(), nil
 		default:
 			return "", fmt.Errorf("unknown info_type: %s", req.InfoType)
 		}
@@ -169,6 +216,12 @@ func setupBuiltInTools() {
 	}`), func(ctx context.Context, args string) (string, error) {
 		return listMemoryEntries(ctx)
 	}, true)
+
+	setupDelegateTool()
+
+	for _, n := range []string{"get_yagi_info", delegateToolName, "saveMemoryEntry", "getMemoryEntry", "deleteMemoryEntry", "listMemoryEntries"} {
+		setToolSource(n, "built-in", "")
+	}
 }
 
 type parsedFlags struct {
@@ -177,6 +230,11 @@ type parsedFlags struct {
 	listFlag    bool
 	showVersion bool
 	stdioMode   bool
+	acpMode     bool
+	mcpServer   bool
+	mcpHTTPAddr string
+	serveAddr   string
+	compareFlag string
 	skillFlag   string
 	resumeFlag  bool
 }
@@ -197,6 +255,12 @@ func parseFlags() parsedFlags {
 	flag.BoolVar(&skipApproval, "yes", false, "Skip plugin approval prompts (use with caution)")
 	flag.BoolVar(&f.showVersion, "v", false, "Show version")
 	flag.BoolVar(&f.stdioMode, "stdio", false, "Run in STDIO mode for editor integration")
+	flag.BoolVar(&stdioAutoApprove, "stdio-auto-approve", false, "With -stdio, run tools without asking the editor for approval")
+	flag.BoolVar(&f.acpMode, "acp", false, "Run as an Agent Client Protocol agent over stdio (e.g. for Zed)")
+	flag.BoolVar(&f.mcpServer, "mcp-server", false, "Serve yagi's tools and a chat tool as an MCP server over stdio")
+	flag.StringVar(&f.mcpHTTPAddr, "mcp-http", "", "With -mcp-server, serve over streamable HTTP on this address (e.g. 127.0.0.1:8081)")
+	flag.StringVar(&f.serveAddr, "serve", "", "Serve an OpenAI-compatible API on this address (e.g. 127.0.0.1:8080)")
+	flag.StringVar(&f.compareFlag, "compare", "", "Answer the prompt with each of these comma-separated models (e.g. openai/gpt-4.1,google/gemini-2.5-flash)")
 	flag.StringVar(&f.skillFlag, "skill", "", "Use a specific skill (e.g., 'explain', 'refactor', 'debug')")
 	flag.BoolVar(&f.resumeFlag, "resume", false, "Resume previous session for the current directory")
 	flag.Parse()
@@ -204,7 +268,7 @@ func parseFlags() parsedFlags {
 	return f
 }
 
-func loadConfigurations() string {
+func getConfigDir() string {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
 		u, err := user.Current()
@@ -213,7 +277,14 @@ func loadConfigurations() string {
 		}
 		configDir = filepath.Join(u.HomeDir, ".config")
 	}
-	configDir = filepath.Join(configDir, "yagi")
+	return filepath.Join(configDir, "yagi")
+}
+
+func loadConfigurations() string {
+	configDir := getConfigDir()
+	if configDir == "" {
+		return ""
+	}
 	if err := loadConfig(configDir); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to load config: %v\n", err)
 	}
@@ -229,8 +300,15 @@ func loadConfigurations() string {
 	if err := loadPlugins(filepath.Join(configDir, "tools"), configDir); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to load plugins: %v\n", err)
 	}
-	if err := loadMCPConfig(configDir); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to load MCP config: %v\n", err)
+	if err := loadManagedPlugins(configDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to load managed plugins: %v\n", err)
+	}
+	// When yagi is itself an MCP server, its own MCP servers are not
+	// connected; a yagi listed in mcp.json would otherwise spawn itself.
+	if !mcpServerMode {
+		if err := loadMCPConfig(configDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to load MCP config: %v\n", err)
+		}
 	}
 	if err := loadExtraProviders(configDir); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to load extra providers: %v\n", err)
@@ -244,33 +322,23 @@ func setupProvider(modelFlag, apiKeyFlag string) *openai.Client {
 		fmt.Fprintf(os.Stderr, "Invalid model format: %s\nUse provider/model format (e.g. google/gemini-2.5-pro)\nRun with -list to see available providers.\n", modelFlag)
 		os.Exit(1)
 	}
-	selectedProvider = findProvider(providerName)
-	if selectedProvider == nil {
+	p := findProvider(providerName)
+	if p == nil {
 		fmt.Fprintf(os.Stderr, "Unknown provider: %s\nRun with -list to see available providers.\n", providerName)
 		os.Exit(1)
 	}
 
-	model = modelName
-
 	apiKey := apiKeyFlag
-	if apiKey == "" && selectedProvider.EnvKey != "" {
-		apiKey = os.Getenv(selectedProvider.EnvKey)
+	if apiKey == "" && p.EnvKey != "" {
+		apiKey = os.Getenv(p.EnvKey)
 		if apiKey == "" {
-			fmt.Fprintf(os.Stderr, "%s environment variable or -key flag is required\n", selectedProvider.EnvKey)
+			fmt.Fprintf(os.Stderr, "%s environment variable or -key flag is required\n", p.EnvKey)
 			os.Exit(1)
 		}
 	}
 
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = selectedProvider.APIURL
-	client := openai.NewClientWithConfig(config)
-	eng.SetClient(client)
-	eng.SetModel(model)
-
-	if mi := findModelInfo(modelFlag); mi != nil && mi.MaxContextChars > 0 {
-		eng.SetContextLimits(mi.MaxContextChars*8/10, mi.MaxContextChars)
-	}
-
+	client := provider.NewClient(p, apiKey)
+	setActiveModel(p, client, modelName)
 	return client
 }
 
@@ -294,7 +362,7 @@ func readOneshotInput() string {
 
 func runInteractiveLoop(client *openai.Client, skillFlag, configDir string, resume bool) {
 	if !quiet {
-		fmt.Fprintf(os.Stderr, "Chat [%s/%s] (type 'exit' to quit)\n", selectedProvider.Name, model)
+		fmt.Fprintf(os.Stderr, "Chat [%s] (type 'exit' to quit)\n", currentModelName())
 		fmt.Fprintln(os.Stderr)
 	}
 
@@ -412,7 +480,7 @@ func runInteractiveLoop(client *openai.Client, skillFlag, configDir string, resu
 
 		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleUser,
-			Content: input,
+			Content: attachMCPResources(context.Background(), input),
 		})
 
 		runChat(&messages, skillFlag)
@@ -461,10 +529,17 @@ Format your response as:
 		Content: planPrompt,
 	})
 
-	stream, err := eng.Client().CreateChatCompletionStream(
+	client, model := eng.ClientAndModel()
+	if c, ok := routedModel(routePlan); ok {
+		if !quiet {
+			fmt.Fprintf(stderr, "\x1b[33m[model: %s (route: %s)]\x1b[0m\n", c.Name, routePlan)
+		}
+		client, model = c.Client, c.Model
+	}
+	stream, err := client.CreateChatCompletionStream(
 		ctx,
 		openai.ChatCompletionRequest{
-			Model:    eng.Model(),
+			Model:    model,
 			Messages: messages,
 			Tools:    eng.Tools(),
 		},
@@ -491,50 +566,49 @@ Format your response as:
 	return plan.String(), nil
 }
 
-func handleSlashCommand(input string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
-	var prevProvider *Provider
-	var prevModel string
-	if selectedProvider != nil {
-		prevProvider = &Provider{
-			Name:   selectedProvider.Name,
-			APIURL: selectedProvider.APIURL,
-			EnvKey: selectedProvider.EnvKey,
-		}
-		prevModel = model
-	}
-
-	parts := strings.Fields(input)
-	cmd := parts[0]
-	args := ""
-	if len(parts) > 1 {
-		args = strings.Join(parts[1:], " ")
-	}
+// slashCommandFunc handles a built-in slash command.
+type slashCommandFunc func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string)
 
-	switch cmd {
-	case "/help":
+// slashCommands are the built-in slash commands. Plugins cannot register
+// commands with these names.
+var slashCommands = map[string]slashCommandFunc{
+	"/help": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		fmt.Println("Available commands:")
 		fmt.Println("  /model [name]   - Show/change model (e.g., /model openai/gpt-4o)")
+		fmt.Println("  /compare m1,m2  - Ask several models a prompt and continue with one answer")
 		fmt.Println("  /agent [on|off] - Toggle autonomous mode (auto-execute tools without approval)")
 		fmt.Println("  /plan [on|off]  - Toggle planning mode (show execution plan before acting)")
 		fmt.Println("  /mode           - Show current mode settings")
 		fmt.Println("  /edit           - Open $EDITOR to compose a message")
 		fmt.Println("  /clear          - Clear conversation history")
 		fmt.Println("  /revoke [name]  - Revoke plugin approval (use 'all' to revoke all)")
+		fmt.Println("  /mcp [cmd]      - Show MCP server status (tools, restart, resources, prompts)")
 		fmt.Println("  /exit           - Exit yagi")
 		fmt.Println("  /help           - Show this help")
+		if len(pluginCommands) > 0 {
+			fmt.Println()
+			fmt.Println("Plugin commands:")
+			for _, c := range pluginCommands {
+				fmt.Printf("  %-15s - %s\n", c.Name, c.Help)
+			}
+		}
+		if prompts := mcpPromptCommands(); len(prompts) > 0 {
+			fmt.Println()
+			fmt.Println("MCP prompts:")
+			for _, c := range prompts {
+				fmt.Printf("  %-15s - %s\n", c.Name, c.prompt.Description)
+			}
+		}
 		fmt.Println()
 		fmt.Println("Tips:")
 		fmt.Println("  - Use Tab for auto-completion")
 		fmt.Println("  - Start with / to see slash commands")
 		fmt.Println("  - Use -model flag to set model on startup")
 		fmt.Println("  - Use -list to see available models")
-	case "/model":
+	},
+	"/model": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		if args == "" {
-			if selectedProvider != nil {
-				fmt.Printf("Current model: %s/%s\n", selectedProvider.Name, model)
-			} else {
-				fmt.Printf("Current model: %s\n", model)
-			}
+			fmt.Printf("Current model: %s\n", currentModelName())
 			return
 		}
 		providerName, modelName, ok := strings.Cut(args, "/")
@@ -547,36 +621,31 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 			fmt.Fprintf(os.Stderr, "Unknown provider: %s\n", providerName)
 			return
 		}
-		selectedProvider = newProvider
-		model = modelName
 		var apiKey string
-		if selectedProvider.EnvKey != "" {
-			apiKey = os.Getenv(selectedProvider.EnvKey)
+		if newProvider.EnvKey != "" {
+			apiKey = os.Getenv(newProvider.EnvKey)
 			if apiKey == "" {
-				fmt.Fprintf(os.Stderr, "Error: %s is not set. Keeping previous model.\n", selectedProvider.EnvKey)
-				selectedProvider = prevProvider
-				model = prevModel
+				fmt.Fprintf(os.Stderr, "Error: %s is not set. Keeping previous model.\n", newProvider.EnvKey)
 				return
 			}
 		}
-		config := openai.DefaultConfig(apiKey)
-		config.BaseURL = selectedProvider.APIURL
-		newClient := openai.NewClientWithConfig(config)
+		newClient := provider.NewClient(newProvider, apiKey)
 		*client = newClient
-		eng.SetClient(newClient)
-		eng.SetModel(model)
-		if mi := findModelInfo(providerName + "/" + modelName); mi != nil && mi.MaxContextChars > 0 {
-			eng.SetContextLimits(mi.MaxContextChars*8/10, mi.MaxContextChars)
-		}
-		fmt.Printf("Model changed to: %s/%s\n", selectedProvider.Name, model)
-	case "/clear":
+		setActiveModel(newProvider, newClient, modelName)
+		fmt.Printf("Model changed to: %s\n", currentModelName())
+	},
+	"/compare": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
+		compareCommand(args, client, configDir, messages, skill)
+	},
+	"/clear": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		*messages = nil
 		workDir, _ := os.Getwd()
 		if configDir != "" && workDir != "" {
 			clearSession(configDir, workDir)
 		}
 		fmt.Println("Conversation cleared.")
-	case "/memory":
+	},
+	"/memory": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		result, err := listMemoryEntries(context.Background())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -584,7 +653,8 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 		}
 		fmt.Println("Saved memories:")
 		fmt.Println(result)
-	case "/revoke":
+	},
+	"/revoke": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		if pluginApprovals == nil {
 			fmt.Fprintf(os.Stderr, "No approval records loaded.\n")
 			return
@@ -632,7 +702,8 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 			}
 			fmt.Printf("Revoked approval for plugin %q.\n", args)
 		}
-	case "/agent":
+	},
+	"/agent": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		if args == "" {
 			if autonomousMode {
 				fmt.Println("Autonomous mode: ON (tools will be executed automatically)")
@@ -653,7 +724,8 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 		default:
 			fmt.Fprintf(os.Stderr, "Usage: /agent [on|off]\n")
 		}
-	case "/plan":
+	},
+	"/plan": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		if args == "" {
 			if planningMode {
 				fmt.Println("Planning mode: ON (execution plan will be shown before acting)")
@@ -672,7 +744,8 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 		default:
 			fmt.Fprintf(os.Stderr, "Usage: /plan [on|off]\n")
 		}
-	case "/mode":
+	},
+	"/mode": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		fmt.Println("Current mode settings:")
 		if autonomousMode {
 			fmt.Println("  Autonomous mode: ON")
@@ -684,7 +757,8 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 		} else {
 			fmt.Println("  Planning mode:   OFF")
 		}
-	case "/edit":
+	},
+	"/edit": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
 		text, err := openEditor(args)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -700,6 +774,28 @@ func handleSlashCommand(input string, client **openai.Client, configDir string,
 			return
 		}
 		setReadlineBuffer(text)
+	},
+	"/mcp": func(args string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
+		handleMCPCommand(args)
+	},
+}
+
+func handleSlashCommand(input string, client **openai.Client, configDir string, messages *[]openai.ChatCompletionMessage, skill string) {
+	parts := strings.Fields(input)
+	cmd := parts[0]
+	args := ""
+	if len(parts) > 1 {
+		args = strings.Join(parts[1:], " ")
+	}
+
+	if fn, ok := slashCommands[cmd]; ok {
+		fn(args, client, configDir, messages, skill)
+		return
+	}
+	if c := findPluginCommand(cmd); c != nil {
+		runPluginSlashCommand(c, args)
+	} else if c := findMCPPromptCommand(cmd); c != nil {
+		runMCPPromptCommand(c, args, messages, skill)
 	}
 }
 
@@ -711,16 +807,31 @@ func main() {
 		return
 	}
 
-	if f.stdioMode {
+	if flag.NArg() > 0 && flag.Arg(0) == "plugin" {
+		if err := runPluginCommand(getConfigDir(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// There is no terminal to ask on. In STDIO mode tools are approved by the
+	// editor instead (see toolApprover).
+	if f.stdioMode || f.acpMode || f.mcpServer || f.serveAddr != "" {
 		quiet = true
 		skipApproval = true
 		autonomousMode = true
+		headless = true
 	}
+	mcpServerMode = f.mcpServer
 
 	eng = engine.New(engine.Config{
 		SystemMessage: func(skill string) string {
 			return getSystemMessage(skill)
 		},
+		Approver:  newToolApprover(),
+		Fallbacks: modelFallbacks,
+		Route:     routedModel,
 	})
 
 	configDir := loadConfigurations()
@@ -728,12 +839,22 @@ func main() {
 
 	setupBuiltInTools()
 
+	if flag.NArg() > 0 && flag.Arg(0) == "tool" {
+		if err := runToolCommand(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			closeMCPConnections()
+			os.Exit(1)
+		}
+		return
+	}
+
 	if f.listFlag {
 		listModels(flag.Args())
 		return
 	}
 
 	client := setupProvider(f.modelFlag, f.apiKeyFlag)
+	checkRouting()
 
 	if f.stdioMode {
 		if err := runSTDIOMode(); err != nil {
@@ -743,12 +864,47 @@ func main() {
 		return
 	}
 
+	if f.acpMode {
+		if err := runACPMode(); err != nil {
+			fmt.Fprintf(os.Stderr, "ACP error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if f.serveAddr != "" {
+		if err := runServeMode(f.serveAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if f.mcpServer {
+		if err := runMCPServerMode(f.mcpHTTPAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "MCP server error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if f.compareFlag != "" {
+		if err := runCompareMode(f.compareFlag, f.skillFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	runLifecycleHooks("OnStart")
+	defer runLifecycleHooks("OnExit")
+
 	oneshot := readOneshotInput()
 	if oneshot != "" {
 		messages := []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleUser,
-				Content: oneshot,
+				Content: attachMCPResources(context.Background(), oneshot),
 			},
 		}
 		oneshotMode = true
@@ -808,6 +964,11 @@ func runChat(messages *[]openai.ChatCompletionMessage, skill string) {
 				}
 			}
 		},
+		OnToolProgress: func(name, text string) {
+			if !quiet {
+				fmt.Fprint(stderr, "\x1b[2m"+text+"\x1b[0m")
+			}
+		},
 		OnToolError: func(name, errMsg string) {
 			if !quiet {
 				fmt.Fprintf(stderr, "\x1b[31m[tool error: %s]\x1b[0m\n", errMsg)
@@ -818,8 +979,15 @@ func runChat(messages *[]openai.ChatCompletionMessage, skill string) {
 				fmt.Fprintf(stderr, "\x1b[33m[context compressed: %d chars → summarized]\x1b[0m\n", oldChars)
 			}
 		},
+		OnModel: func(name, reason string) {
+			if !quiet {
+				fmt.Fprintf(stderr, "\x1b[33m[model: %s (%s)]\x1b[0m\n", name, reason)
+			}
+		},
 	}
+	pinActiveModel(&opts)
 
+	*messages = runTurnHooks("BeforeTurn", *messages)
 	_, updatedMsgs, err := eng.Chat(ctx, *messages, opts)
 	if err != nil {
 		if ctx.Err() != nil {
@@ -835,7 +1003,7 @@ func runChat(messages *[]openai.ChatCompletionMessage, skill string) {
 			fmt.Print(rest)
 		}
 	}
-	*messages = updatedMsgs
+	*messages = runTurnHooks("AfterTurn", updatedMsgs)
 }
 
 func listModels(args []string) {
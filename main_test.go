@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
 	openai "github.com/sashabaranov/go-openai"
@@ -48,3 +51,221 @@ func TestRegisterTool_Multiple(t *testing.T) {
 		}
 	}
 }
+
+func registerEchoTool(e *engine.Engine) {
+	params := json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"key": {"type": "string"},
+			"count": {"type": "integer"}
+		},
+		"required": ["key"]
+	}`)
+	e.RegisterTool("echo", "Echo arguments", params, func(ctx context.Context, args string) (string, error) {
+		return "got " + args, nil
+	}, true)
+}
+
+func TestExecuteTool_ValidArguments(t *testing.T) {
+	e := newTestEngine()
+	registerEchoTool(e)
+
+	got := e.ExecuteTool(context.Background(), "echo", `{"key":"a","count":2}`)
+	if got != `got {"key":"a","count":2}` {
+		t.Errorf("ExecuteTool = %q", got)
+	}
+}
+
+func TestExecuteTool_MissingRequired(t *testing.T) {
+	e := newTestEngine()
+	registerEchoTool(e)
+
+	got := e.ExecuteTool(context.Background(), "echo", `{"count":2}`)
+	if !strings.Contains(got, `"error":"invalid_arguments"`) || !strings.Contains(got, "key") {
+		t.Errorf("expected validation error mentioning key, got %q", got)
+	}
+}
+
+func TestExecuteTool_WrongType(t *testing.T) {
+	e := newTestEngine()
+	registerEchoTool(e)
+
+	got := e.ExecuteTool(context.Background(), "echo", `{"key":"a","count":"two"}`)
+	if !strings.Contains(got, `"error":"invalid_arguments"`) {
+		t.Errorf("expected validation error, got %q", got)
+	}
+}
+
+func TestExecuteTool_RepairsTrailingComma(t *testing.T) {
+	e := newTestEngine()
+	registerEchoTool(e)
+
+	got := e.ExecuteTool(context.Background(), "echo", "```json\n{\"key\": \"a,}\",}\n```")
+	if got != `got {"key": "a,}"}` {
+		t.Errorf("ExecuteTool = %q", got)
+	}
+}
+
+func TestExecuteTool_InvalidJSON(t *testing.T) {
+	e := newTestEngine()
+	registerEchoTool(e)
+
+	got := e.ExecuteTool(context.Background(), "echo", `{"key": `)
+	if !strings.Contains(got, "not valid JSON") {
+		t.Errorf("expected JSON error, got %q", got)
+	}
+}
+
+func TestExecuteTool_EmptyArgumentsWithoutSchema(t *testing.T) {
+	e := newTestEngine()
+	e.RegisterTool("noargs", "No arguments", json.RawMessage(`{}`), func(ctx context.Context, args string) (string, error) {
+		return "args=" + args, nil
+	}, true)
+
+	if got := e.ExecuteTool(context.Background(), "noargs", ""); got != "args={}" {
+		t.Errorf("ExecuteTool = %q, want %q", got, "args={}")
+	}
+}
+
+func TestChat_ToolProgress(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("build", "{}")}},
+		fakeTurn{Content: "built"},
+	)
+	e := engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	e.RegisterTool("build", "Build", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		engine.ReportProgress(ctx, "step 1\n")
+		engine.ReportProgress(ctx, "step 2\n")
+		return "ok", nil
+	}, true)
+
+	var progress []string
+	content, _, err := e.Chat(context.Background(), engine.UserMessage("build it"), engine.ChatOptions{
+		OnToolProgress: func(name, text string) {
+			progress = append(progress, name+":"+text)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if content != "built" {
+		t.Errorf("content = %q, want %q", content, "built")
+	}
+	if strings.Join(progress, "") != "build:step 1\nbuild:step 2\n" {
+		t.Errorf("progress = %q", progress)
+	}
+}
+
+func TestReportProgress_NoCallback(t *testing.T) {
+	engine.ReportProgress(context.Background(), "ignored")
+}
+
+func TestChat_ConcurrentOverrides(t *testing.T) {
+	llmA := newFakeLLM(t)
+	llmB := newFakeLLM(t)
+	eng = engine.New(engine.Config{SystemMessage: func(string) string { return "default system" }})
+	for _, name := range []string{"x", "y"} {
+		eng.RegisterTool(name, name, json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+			return "", nil
+		}, true)
+	}
+	savedProviders := providers
+	providers = []Provider{{Name: "a", APIURL: llmA.srv.URL + "/v1"}, {Name: "b", APIURL: llmB.srv.URL + "/v1"}}
+	setActiveModel(&providers[0], llmA.client(), "m0")
+	defer func() {
+		providers = savedProviders
+		modelMu.Lock()
+		selectedProvider = nil
+		modelMu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var opts engine.ChatOptions
+			if err := (RequestOptions{Model: "b/big", Tools: []string{"x"}}).apply(&opts); err != nil {
+				t.Error(err)
+				return
+			}
+			opts.SystemMessage = "custom system"
+			if _, _, err := eng.Chat(context.Background(), engine.UserMessage("hi"), opts); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var opts engine.ChatOptions
+			if err := (RequestOptions{}).apply(&opts); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, _, err := eng.Chat(context.Background(), engine.UserMessage("hi"), opts); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		setActiveModel(&providers[0], llmA.client(), fmt.Sprintf("m%d", i%2))
+		eng.RegisterTool("z", "z", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+			return "", nil
+		}, true)
+		eng.UnregisterTool("z")
+	}
+	wg.Wait()
+
+	for _, r := range llmB.Requests() {
+		if r.Model != "big" || len(r.Tools) != 1 || r.Tools[0].Function.Name != "x" || r.Messages[0].Content != "custom system" {
+			t.Errorf("overridden request = model %q, %d tools, system %q", r.Model, len(r.Tools), r.Messages[0].Content)
+		}
+	}
+	for _, r := range llmA.Requests() {
+		if !strings.HasPrefix(r.Model, "m") || r.Messages[0].Content != "default system" {
+			t.Errorf("default request = model %q, system %q", r.Model, r.Messages[0].Content)
+		}
+	}
+	if n := len(llmA.Requests()) + len(llmB.Requests()); n != 20 {
+		t.Errorf("%d model calls, want 20", n)
+	}
+}
+
+func TestChat_PinnedModel(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("wait", "{}")}},
+		fakeTurn{Content: "done"},
+	)
+	eng = engine.New(engine.Config{})
+	started, release := make(chan struct{}), make(chan struct{})
+	eng.RegisterTool("wait", "Wait", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		close(started)
+		<-release
+		return "", nil
+	}, true)
+	p := &Provider{Name: "a", APIURL: llm.srv.URL + "/v1"}
+	setActiveModel(p, llm.client(), "old")
+	defer func() {
+		modelMu.Lock()
+		selectedProvider = nil
+		modelMu.Unlock()
+	}()
+
+	done := make(chan error)
+	go func() {
+		var opts engine.ChatOptions
+		pinActiveModel(&opts)
+		_, _, err := eng.Chat(context.Background(), engine.UserMessage("hi"), opts)
+		done <- err
+	}()
+	<-started
+	setActiveModel(p, llm.client(), "new")
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range llm.Requests() {
+		if r.Model != "old" {
+			t.Errorf("model switched mid-chat to %q", r.Model)
+		}
+	}
+}
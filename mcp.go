@@ -3,30 +3,128 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yagi-agent/yagi/engine"
 )
 
 type MCPServerConfig struct {
-	Command string   `json:"command"`
-	Args    []string `json:"args"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Cwd     string            `json:"cwd,omitempty"`
+
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Transport string            `json:"transport,omitempty"` // "stdio", "sse" or "streamable-http"
+
+	Disabled bool `json:"disabled,omitempty"`
+	Lazy     bool `json:"lazy,omitempty"`    // start on first use, using the cached tool list
+	Timeout  int  `json:"timeout,omitempty"` // startup timeout in seconds
+
+	// IncludeTools and ExcludeTools are glob patterns over tool names.
+	IncludeTools []string                 `json:"includeTools,omitempty"`
+	ExcludeTools []string                 `json:"excludeTools,omitempty"`
+	Trusted      bool                     `json:"trusted,omitempty"` // all tools run without approval
+	Tools        map[string]MCPToolConfig `json:"tools,omitempty"`
+}
+
+type MCPToolConfig struct {
+	Trusted bool `json:"trusted,omitempty"`
+}
+
+// allowsTool reports whether a tool of the server is offered to the model.
+func (sc MCPServerConfig) allowsTool(name string) bool {
+	if len(sc.IncludeTools) > 0 && !matchAny(sc.IncludeTools, name) {
+		return false
+	}
+	return !matchAny(sc.ExcludeTools, name)
+}
+
+func (sc MCPServerConfig) trustsTool(name string) bool {
+	return sc.Trusted || sc.Tools[name].Trusted
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
 }
 
 type MCPConfig struct {
 	MCPServers map[string]MCPServerConfig `json:"mcpServers"`
 }
 
+const (
+	mcpStatusStopped   = "stopped"
+	mcpStatusConnected = "connected"
+	mcpStatusFailed    = "failed"
+	mcpStatusDisabled  = "disabled"
+
+	mcpMaxBackoff    = time.Minute
+	mcpMaxReconnects = 5
+)
+
 type mcpConnection struct {
-	name    string
-	session *mcp.ClientSession
+	name     string
+	config   MCPServerConfig
+	client   *mcp.Client
+	engine   *engine.Engine // tools are registered here
+	cacheDir string         // where the tool list is cached, if anywhere
+
+	// startMu serializes connection attempts so concurrent tool calls on a
+	// stopped server start it only once.
+	startMu sync.Mutex
+
+	mu         sync.Mutex
+	session    *mcp.ClientSession
+	status     string
+	err        error
+	failures   int
+	retryAt    time.Time
+	closed     bool
+	tools      []*mcp.Tool
+	resources  []*mcp.Resource
+	prompts    []*mcp.Prompt
+	registered []string
 }
 
-var mcpConnections []*mcpConnection
+var (
+	// mcpConnections is guarded by mcpMu; ACP sessions add servers while
+	// chats run.
+	mcpMu          sync.Mutex
+	mcpConnections []*mcpConnection
+	mcpConfigDir   string
+)
+
+// listMCPConnections returns a snapshot of the connections.
+func listMCPConnections() []*mcpConnection {
+	mcpMu.Lock()
+	defer mcpMu.Unlock()
+	return append([]*mcpConnection(nil), mcpConnections...)
+}
+
+func addMCPConnection(conn *mcpConnection) {
+	mcpMu.Lock()
+	defer mcpMu.Unlock()
+	mcpConnections = append(mcpConnections, conn)
+}
 
 func loadMCPConfig(configDir string) error {
 	path := filepath.Join(configDir, "mcp.json")
@@ -42,74 +140,536 @@ func loadMCPConfig(configDir string) error {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return fmt.Errorf("parsing mcp.json: %w", err)
 	}
+	mcpConfigDir = configDir
+	cache := loadMCPToolCache(configDir)
 
-	client := mcp.NewClient(&mcp.Implementation{
+	names := make([]string, 0, len(config.MCPServers))
+	for name := range config.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Servers start in parallel; tools are registered afterwards in name order
+	// so the tool list is stable between runs.
+	var wg sync.WaitGroup
+	for _, name := range names {
+		conn := newMCPConnection(name, config.MCPServers[name])
+		addMCPConnection(conn)
+		if conn.config.Disabled {
+			continue
+		}
+		if conn.config.Lazy {
+			if tools, ok := cache.lookup(name, conn.config); ok {
+				conn.tools = tools
+				continue
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), conn.startupTimeout())
+			defer cancel()
+			if err := conn.start(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: MCP server %q %v\n", conn.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, conn := range listMCPConnections() {
+		conn.registerTools()
+	}
+	return nil
+}
+
+func newMCPConnection(server string, sc MCPServerConfig) *mcpConnection {
+	c := &mcpConnection{name: server, config: sc, engine: eng, cacheDir: mcpConfigDir, status: mcpStatusStopped}
+	if sc.Disabled {
+		c.status = mcpStatusDisabled
+	}
+	c.client = mcp.NewClient(&mcp.Implementation{
 		Name:    name,
 		Version: version,
-	}, nil)
+	}, &mcp.ClientOptions{
+		ToolListChangedHandler: func(ctx context.Context, req *mcp.ToolListChangedRequest) {
+			// Listing tools from inside the notification handler would block
+			// the session, so refresh asynchronously.
+			go c.refreshTools(req.Session)
+		},
+		CreateMessageHandler: c.handleSampling,
+		ElicitationHandler:   c.handleElicitation,
+	})
+	return c
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// connectMCPServer adds a server and starts it right away. The connection is
+// returned even if it failed to start, so the caller can disconnect it.
+func connectMCPServer(ctx context.Context, server string, sc MCPServerConfig) (*mcpConnection, error) {
+	conn := newMCPConnection(server, sc)
+	addMCPConnection(conn)
+	if err := conn.start(ctx); err != nil {
+		return conn, err
+	}
+	conn.registerTools()
+	return conn, nil
+}
 
-	for name, sc := range config.MCPServers {
-		cmd := exec.Command(sc.Command, sc.Args...)
-		transport := &mcp.CommandTransport{Command: cmd}
+// disconnectMCPServer stops a server added by connectMCPServer and removes
+// its tools.
+func disconnectMCPServer(conn *mcpConnection) {
+	mcpMu.Lock()
+	mcpConnections = slices.DeleteFunc(mcpConnections, func(c *mcpConnection) bool { return c == conn })
+	mcpMu.Unlock()
+
+	conn.close()
+	conn.mu.Lock()
+	registered := conn.registered
+	conn.registered = nil
+	conn.mu.Unlock()
+	for _, name := range registered {
+		conn.unregisterTool(name)
+	}
+}
+
+// unregisterTool removes a tool of this server, unless another server or a
+// plugin has since registered a tool with the same name.
+func (c *mcpConnection) unregisterTool(name string) {
+	if src := getToolSource(name); src.Kind == "mcp" && src.Origin == c.name {
+		c.engine.UnregisterTool(name)
+	}
+}
+
+func findMCPConnection(server string) *mcpConnection {
+	for _, conn := range listMCPConnections() {
+		if conn.name == server {
+			return conn
+		}
+	}
+	return nil
+}
+
+func (c *mcpConnection) startupTimeout() time.Duration {
+	if c.config.Timeout > 0 {
+		return time.Duration(c.config.Timeout) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// start connects to the server and fetches its tools, resources and prompts.
+// Tools are not registered with the engine; call registerTools for that.
+func (c *mcpConnection) start(ctx context.Context) error {
+	transport, err := newMCPTransport(c.config)
+	if err != nil {
+		c.fail(err)
+		return fmt.Errorf("has an invalid configuration: %v", err)
+	}
+
+	session, err := c.client.Connect(ctx, transport, nil)
+	if err != nil {
+		c.fail(err)
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+
+	var tools []*mcp.Tool
+	var resources []*mcp.Resource
+	var prompts []*mcp.Prompt
+	err = func() error {
+		for t, err := range session.Tools(ctx, nil) {
+			if err != nil {
+				return fmt.Errorf("failed to list tools: %v", err)
+			}
+			tools = append(tools, t)
+		}
+		caps := session.InitializeResult().Capabilities
+		if caps.Resources != nil {
+			for r, err := range session.Resources(ctx, nil) {
+				if err != nil {
+					return fmt.Errorf("failed to list resources: %v", err)
+				}
+				resources = append(resources, r)
+			}
+		}
+		if caps.Prompts != nil {
+			for p, err := range session.Prompts(ctx, nil) {
+				if err != nil {
+					return fmt.Errorf("failed to list prompts: %v", err)
+				}
+				prompts = append(prompts, p)
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		session.Close()
+		c.fail(err)
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		session.Close()
+		return fmt.Errorf("connection closed")
+	}
+	c.session = session
+	c.status = mcpStatusConnected
+	c.err = nil
+	c.failures = 0
+	c.retryAt = time.Time{}
+	c.tools = tools
+	c.resources = resources
+	c.prompts = prompts
+	c.mu.Unlock()
+
+	go c.watch(session)
+	return nil
+}
+
+// fail records a failed start and schedules the next attempt with
+// exponential backoff.
+func (c *mcpConnection) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = mcpStatusFailed
+	c.err = err
+	c.retryAt = time.Now().Add(mcpBackoff(c.failures))
+	c.failures++
+}
+
+func mcpBackoff(attempt int) time.Duration {
+	d := time.Second << uint(min(attempt, 6))
+	return min(d, mcpMaxBackoff)
+}
+
+// watch waits for the session to end. If the server went away on its own,
+// it is restarted in the background.
+func (c *mcpConnection) watch(session *mcp.ClientSession) {
+	err := session.Wait()
+
+	c.mu.Lock()
+	if c.closed || c.session != session {
+		c.mu.Unlock()
+		return
+	}
+	c.session = nil
+	c.status = mcpStatusStopped
+	c.err = err
+	c.mu.Unlock()
+
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Warning: MCP server %q disconnected, reconnecting\n", c.name)
+	}
+	for attempt := 0; attempt < mcpMaxReconnects; attempt++ {
+		time.Sleep(mcpBackoff(attempt))
+		c.mu.Lock()
+		done := c.closed || c.session != nil
+		c.mu.Unlock()
+		if done {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.startupTimeout())
+		_, err := c.ensureSession(ctx, true)
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+}
+
+// getSession returns the live session, starting the server if it is not
+// running. After a failed start, further attempts wait for the backoff.
+func (c *mcpConnection) getSession(ctx context.Context) (*mcp.ClientSession, error) {
+	return c.ensureSession(ctx, false)
+}
+
+func (c *mcpConnection) ensureSession(ctx context.Context, ignoreBackoff bool) (*mcp.ClientSession, error) {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	c.mu.Lock()
+	session, status, lastErr, retryAt := c.session, c.status, c.err, c.retryAt
+	c.mu.Unlock()
+	if session != nil {
+		return session, nil
+	}
+	if status == mcpStatusDisabled {
+		return nil, fmt.Errorf("MCP server %q is disabled", c.name)
+	}
+	if wait := time.Until(retryAt); wait > 0 && !ignoreBackoff {
+		return nil, fmt.Errorf("MCP server %q is unavailable (retrying in %s): %v", c.name, wait.Round(time.Second), lastErr)
+	}
+
+	if err := c.start(ctx); err != nil {
+		return nil, fmt.Errorf("MCP server %q %v", c.name, err)
+	}
+	c.registerTools()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session, nil
+}
+
+// restart closes the session, if any, and starts the server again.
+func (c *mcpConnection) restart(ctx context.Context) error {
+	c.mu.Lock()
+	old := c.session
+	c.session = nil
+	c.status = mcpStatusStopped
+	c.failures = 0
+	c.retryAt = time.Time{}
+	if c.config.Disabled {
+		c.status = mcpStatusDisabled
+	}
+	c.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	_, err := c.getSession(ctx)
+	return err
+}
+
+func (c *mcpConnection) refreshTools(session *mcp.ClientSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.startupTimeout())
+	defer cancel()
 
-		session, err := client.Connect(ctx, transport, nil)
+	var tools []*mcp.Tool
+	for t, err := range session.Tools(ctx, nil) {
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: MCP server %q failed to connect: %v\n", name, err)
+			fmt.Fprintf(os.Stderr, "Warning: MCP server %q failed to list tools: %v\n", c.name, err)
+			return
+		}
+		tools = append(tools, t)
+	}
+	c.mu.Lock()
+	if c.closed || c.session != session {
+		c.mu.Unlock()
+		return
+	}
+	c.tools = tools
+	c.mu.Unlock()
+	c.registerTools()
+}
+
+// registerTools syncs the engine with the server's current tool list.
+func (c *mcpConnection) registerTools() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	tools := c.tools
+	previous := c.registered
+	c.mu.Unlock()
+
+	current := make(map[string]bool, len(tools))
+	var names []string
+	for _, tool := range tools {
+		if !c.config.allowsTool(tool.Name) {
 			continue
 		}
+		current[tool.Name] = true
+		names = append(names, tool.Name)
+		if src := getToolSource(tool.Name); c.engine.HasTool(tool.Name) && (src.Kind != "mcp" || src.Origin != c.name) {
+			fmt.Fprintf(os.Stderr, "Warning: MCP server %q replaces tool %s from %s\n", c.name, tool.Name, src)
+		}
+		c.engine.RegisterTool(tool.Name, tool.Description, marshalSchema(tool.InputSchema), c.callTool(tool.Name), c.config.trustsTool(tool.Name))
+		setToolSource(tool.Name, "mcp", c.name)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Loaded MCP tool: %s (from %s)\n", tool.Name, c.name)
+		}
+	}
+	for _, name := range previous {
+		if !current[name] {
+			c.unregisterTool(name)
+		}
+	}
 
-		conn := &mcpConnection{name: name, session: session}
-		mcpConnections = append(mcpConnections, conn)
+	c.mu.Lock()
+	c.registered = names
+	connected := c.session != nil
+	c.mu.Unlock()
+	if connected && c.cacheDir != "" {
+		if err := saveMCPToolCache(c.cacheDir, c.name, c.config, tools); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save MCP tool cache: %v\n", err)
+		}
+	}
+}
 
-		result, err := session.ListTools(ctx, &mcp.ListToolsParams{})
+func (c *mcpConnection) callTool(toolName string) engine.ToolFunc {
+	return func(ctx context.Context, arguments string) (string, error) {
+		var args map[string]any
+		json.Unmarshal([]byte(arguments), &args)
+
+		callCtx, callCancel := context.WithTimeout(ctx, 60*time.Second)
+		defer callCancel()
+
+		params := &mcp.CallToolParams{
+			Name:      toolName,
+			Arguments: args,
+		}
+		session, err := c.getSession(callCtx)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: MCP server %q failed to list tools: %v\n", name, err)
-			continue
+			return "", err
+		}
+		res, err := session.CallTool(callCtx, params)
+		if errors.Is(err, mcp.ErrConnectionClosed) {
+			// The server went away since the last call; start it again and
+			// retry once.
+			c.mu.Lock()
+			if c.session == session {
+				c.session = nil
+				c.status = mcpStatusStopped
+			}
+			c.mu.Unlock()
+			if session, err = c.getSession(callCtx); err == nil {
+				res, err = session.CallTool(callCtx, params)
+			}
 		}
 
-		for _, tool := range result.Tools {
-			toolName := tool.Name
-			sess := session
-			eng.RegisterTool(
-				toolName,
-				tool.Description,
-				marshalSchema(tool.InputSchema),
-				func(ctx context.Context, arguments string) (string, error) {
-					var args map[string]any
-					json.Unmarshal([]byte(arguments), &args)
-
-					callCtx, callCancel := context.WithTimeout(ctx, 60*time.Second)
-					defer callCancel()
-
-					res, err := sess.CallTool(callCtx, &mcp.CallToolParams{
-						Name:      toolName,
-						Arguments: args,
-					})
-
-					if err != nil {
-						return "", fmt.Errorf("%v", err)
-					}
-					if res.IsError {
-						return "", fmt.Errorf("tool error: %s", contentToString(res.Content))
-					}
-					return contentToString(res.Content), nil
-				},
-				false,
-			)
-			if verbose {
-				fmt.Fprintf(os.Stderr, "Loaded MCP tool: %s (from %s)\n", toolName, name)
+		if err != nil {
+			return "", fmt.Errorf("%v", err)
+		}
+		if res.IsError {
+			return "", fmt.Errorf("tool error: %s", contentToString(ctx, res.Content))
+		}
+		text := contentToString(ctx, res.Content)
+		if res.StructuredContent != nil {
+			engine.AttachStructured(ctx, res.StructuredContent)
+			if text == "" {
+				b, _ := json.Marshal(res.StructuredContent)
+				text = string(b)
 			}
 		}
+		return text, nil
 	}
-	return nil
 }
 
 func closeMCPConnections() {
-	for _, conn := range mcpConnections {
-		conn.session.Close()
+	for _, conn := range listMCPConnections() {
+		conn.close()
+	}
+}
+
+// close ends the session and keeps the server from being restarted.
+func (c *mcpConnection) close() {
+	c.mu.Lock()
+	c.closed = true
+	session := c.session
+	c.session = nil
+	c.mu.Unlock()
+	if session != nil {
+		session.Close()
+	}
+}
+
+// mcpToolCache remembers the tools of each server so lazy servers can offer
+// them without being started.
+type mcpToolCache map[string]mcpCachedServer
+
+type mcpCachedServer struct {
+	Hash  string      `json:"hash"`
+	Tools []*mcp.Tool `json:"tools"`
+}
+
+var mcpCacheMu sync.Mutex
+
+// mcpConfigHash identifies how a server is launched, so a cached tool list
+// is discarded when the server changes.
+func mcpConfigHash(sc MCPServerConfig) string {
+	sc.Disabled, sc.Lazy, sc.Timeout = false, false, 0
+	sc.IncludeTools, sc.ExcludeTools, sc.Trusted, sc.Tools = nil, nil, false, nil
+	b, _ := json.Marshal(sc)
+	return computeHash(b)
+}
+
+func loadMCPToolCache(configDir string) mcpToolCache {
+	mcpCacheMu.Lock()
+	defer mcpCacheMu.Unlock()
+	cache := mcpToolCache{}
+	data, err := os.ReadFile(filepath.Join(configDir, "mcp_cache.json"))
+	if err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+func (cache mcpToolCache) lookup(name string, sc MCPServerConfig) ([]*mcp.Tool, bool) {
+	entry, ok := cache[name]
+	if !ok || entry.Hash != mcpConfigHash(sc) {
+		return nil, false
+	}
+	return entry.Tools, true
+}
+
+func saveMCPToolCache(configDir, name string, sc MCPServerConfig, tools []*mcp.Tool) error {
+	cache := loadMCPToolCache(configDir)
+	mcpCacheMu.Lock()
+	defer mcpCacheMu.Unlock()
+	cache[name] = mcpCachedServer{Hash: mcpConfigHash(sc), Tools: tools}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(configDir, "mcp_cache.json"), data, 0o644)
+}
+
+type headerRoundTripper struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	return h.base.RoundTrip(req)
+}
+
+// newMCPTransport builds the transport for a server entry. Header values and
+// env values may reference environment variables as $VAR or ${VAR}.
+func newMCPTransport(sc MCPServerConfig) (mcp.Transport, error) {
+	kind := sc.Transport
+	if kind == "" {
+		if sc.URL != "" {
+			kind = "streamable-http"
+		} else {
+			kind = "stdio"
+		}
+	}
+
+	switch kind {
+	case "stdio":
+		if sc.Command == "" {
+			return nil, fmt.Errorf("command is required for stdio transport")
+		}
+		cmd := exec.Command(sc.Command, sc.Args...)
+		cmd.Dir = sc.Cwd
+		if len(sc.Env) > 0 {
+			cmd.Env = os.Environ()
+			for k, v := range sc.Env {
+				cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+			}
+		}
+		return &mcp.CommandTransport{Command: cmd}, nil
+	case "sse", "streamable-http", "http":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("url is required for %s transport", kind)
+		}
+		httpClient := http.DefaultClient
+		if len(sc.Headers) > 0 {
+			headers := make(map[string]string, len(sc.Headers))
+			for k, v := range sc.Headers {
+				headers[k] = os.ExpandEnv(v)
+			}
+			httpClient = &http.Client{Transport: &headerRoundTripper{base: http.DefaultTransport, headers: headers}}
+		}
+		if kind == "sse" {
+			return &mcp.SSEClientTransport{Endpoint: sc.URL, HTTPClient: httpClient}, nil
+		}
+		return &mcp.StreamableClientTransport{Endpoint: sc.URL, HTTPClient: httpClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", kind)
 	}
 }
 
@@ -124,12 +684,179 @@ func marshalSchema(schema any) json.RawMessage {
 	return b
 }
 
-func contentToString(content []mcp.Content) string {
-	var result string
+// contentToString renders tool or prompt content as text for the model.
+// Images are attached to the tool result when the model can see them and
+// saved to a temporary file otherwise.
+func contentToString(ctx context.Context, content []mcp.Content) string {
+	var sb strings.Builder
+	// Text is concatenated as is; other content is put on lines of its own.
+	afterBlock := false
+	block := func(text string) {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(text + "\n")
+		afterBlock = true
+	}
 	for _, c := range content {
-		if tc, ok := c.(*mcp.TextContent); ok {
-			result += tc.Text
+		switch c := c.(type) {
+		case *mcp.TextContent:
+			sb.WriteString(c.Text)
+			afterBlock = false
+		case *mcp.ImageContent:
+			if engine.AttachImage(ctx, c.MIMEType, c.Data) {
+				block(fmt.Sprintf("[image: %s, attached]", c.MIMEType))
+			} else {
+				block(saveMCPBlob("image", c.MIMEType, c.Data))
+			}
+		case *mcp.AudioContent:
+			block(saveMCPBlob("audio", c.MIMEType, c.Data))
+		case *mcp.EmbeddedResource:
+			if c.Resource == nil {
+				continue
+			}
+			if c.Resource.Text != "" || len(c.Resource.Blob) == 0 {
+				block(fmt.Sprintf("<resource uri=%q>\n%s\n</resource>", c.Resource.URI, c.Resource.Text))
+			} else {
+				block(fmt.Sprintf("[resource %s: %s]", c.Resource.URI, saveMCPBlob("resource", c.Resource.MIMEType, c.Resource.Blob)))
+			}
+		case *mcp.ResourceLink:
+			link := fmt.Sprintf("[resource link: %s <%s>", c.Name, c.URI)
+			if c.MIMEType != "" {
+				link += " (" + c.MIMEType + ")"
+			}
+			if c.Description != "" {
+				link += " - " + c.Description
+			}
+			block(link + "]")
+		}
+	}
+	if afterBlock {
+		return strings.TrimSuffix(sb.String(), "\n")
+	}
+	return sb.String()
+}
+
+func saveMCPBlob(kind, mimeType string, data []byte) string {
+	ext := ""
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		ext = exts[0]
+	}
+	f, err := os.CreateTemp("", "yagi-mcp-*"+ext)
+	if err != nil {
+		return fmt.Sprintf("[%s: %s, %d bytes, not saved: %v]", kind, mimeType, len(data), err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Sprintf("[%s: %s, %d bytes, not saved: %v]", kind, mimeType, len(data), err)
+	}
+	return fmt.Sprintf("[%s: %s, %d bytes, saved to %s]", kind, mimeType, len(data), f.Name())
+}
+
+func handleMCPCommand(args string) {
+	fields := strings.Fields(args)
+	sub := ""
+	if len(fields) > 0 {
+		sub = fields[0]
+	}
+	switch sub {
+	case "", "status":
+		conns := listMCPConnections()
+		if len(conns) == 0 {
+			fmt.Println("No MCP servers configured.")
+			return
+		}
+		for _, conn := range conns {
+			conn.mu.Lock()
+			status := conn.status
+			if status == mcpStatusStopped && conn.config.Lazy {
+				status += " (lazy)"
+			}
+			line := fmt.Sprintf("  %-20s %-16s %d tools, %d resources, %d prompts",
+				conn.name, status, len(conn.tools), len(conn.resources), len(conn.prompts))
+			if conn.err != nil && conn.status != mcpStatusConnected {
+				line += fmt.Sprintf(" (%v)", conn.err)
+			}
+			conn.mu.Unlock()
+			fmt.Println(line)
+		}
+	case "tools":
+		for _, conn := range listMCPConnections() {
+			if len(fields) > 1 && conn.name != fields[1] {
+				continue
+			}
+			conn.mu.Lock()
+			tools := conn.tools
+			conn.mu.Unlock()
+			for _, t := range tools {
+				if !conn.config.allowsTool(t.Name) {
+					continue
+				}
+				desc, _, _ := strings.Cut(t.Description, "\n")
+				if conn.config.trustsTool(t.Name) {
+					desc = "[trusted] " + desc
+				}
+				fmt.Printf("  %-24s %-16s %s\n", t.Name, conn.name, desc)
+			}
+		}
+	case "restart":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /mcp restart <server>")
+			return
+		}
+		conn := findMCPConnection(fields[1])
+		if conn == nil {
+			fmt.Fprintf(os.Stderr, "Error: unknown MCP server: %s\n", fields[1])
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), conn.startupTimeout())
+		defer cancel()
+		if err := conn.restart(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Restarted MCP server %s.\n", conn.name)
+	case "resources":
+		for _, conn := range listMCPConnections() {
+			if len(fields) > 1 && conn.name != fields[1] {
+				continue
+			}
+			conn.mu.Lock()
+			resources := conn.resources
+			conn.mu.Unlock()
+			for _, r := range resources {
+				if r.Description != "" {
+					fmt.Printf("  @%s:%s - %s\n", conn.name, r.URI, r.Description)
+				} else {
+					fmt.Printf("  @%s:%s\n", conn.name, r.URI)
+				}
+			}
+		}
+	case "resource":
+		if len(fields) != 3 {
+			fmt.Println("Usage: /mcp resource <server> <uri>")
+			return
+		}
+		text, err := readMCPResource(context.Background(), fields[1], fields[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		pendingMCPResources = append(pendingMCPResources, text)
+		fmt.Printf("Attached %s:%s to the next message.\n", fields[1], fields[2])
+	case "prompts":
+		for _, c := range mcpPromptCommands() {
+			var names []string
+			for _, a := range c.prompt.Arguments {
+				names = append(names, a.Name)
+			}
+			fmt.Printf("  %-20s - %s", c.Name, c.prompt.Description)
+			if len(names) > 0 {
+				fmt.Printf(" (%s)", strings.Join(names, ", "))
+			}
+			fmt.Println()
 		}
+	default:
+		fmt.Println("Usage: /mcp [status | tools [server] | restart <server> | resources [server] | resource <server> <uri> | prompts]")
 	}
-	return result
 }
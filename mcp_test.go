@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
 )
 
 func TestMarshalSchema_Nil(t *testing.T) {
@@ -52,7 +64,7 @@ func TestMarshalSchema_ValidStruct(t *testing.T) {
 }
 
 func TestContentToString_Empty(t *testing.T) {
-	got := contentToString([]mcp.Content{})
+	got := contentToString(context.Background(), []mcp.Content{})
 	if got != "" {
 		t.Errorf("contentToString(empty) = %q, want %q", got, "")
 	}
@@ -62,7 +74,7 @@ func TestContentToString_SingleText(t *testing.T) {
 	content := []mcp.Content{
 		&mcp.TextContent{Text: "hello"},
 	}
-	got := contentToString(content)
+	got := contentToString(context.Background(), content)
 	if got != "hello" {
 		t.Errorf("contentToString = %q, want %q", got, "hello")
 	}
@@ -73,9 +85,500 @@ func TestContentToString_MultipleText(t *testing.T) {
 		&mcp.TextContent{Text: "hello"},
 		&mcp.TextContent{Text: " world"},
 	}
-	got := contentToString(content)
+	got := contentToString(context.Background(), content)
 	want := "hello world"
 	if got != want {
 		t.Errorf("contentToString = %q, want %q", got, want)
 	}
 }
+
+type addInput struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newTestMCPServer() *mcp.Server {
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "1.0.0"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "add", Description: "Add two numbers"}, func(ctx context.Context, req *mcp.CallToolRequest, in addInput) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{&mcp.TextContent{Text: itoa(in.A + in.B)}},
+		}, nil, nil
+	})
+	mcp.AddTool(server, &mcp.Tool{Name: "snapshot", Description: "Take a snapshot"}, func(ctx context.Context, req *mcp.CallToolRequest, in struct{}) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: "snapshot taken"},
+				&mcp.ImageContent{MIMEType: "image/png", Data: []byte("PNGDATA")},
+				&mcp.EmbeddedResource{Resource: &mcp.ResourceContents{URI: "file:///log.txt", Text: "log line"}},
+				&mcp.ResourceLink{Name: "full", URI: "file:///full.png", MIMEType: "image/png"},
+			},
+			StructuredContent: map[string]any{"width": 640},
+		}, nil, nil
+	})
+	server.AddResource(&mcp.Resource{Name: "readme", URI: "file:///README.md", Description: "Project readme"}, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return &mcp.ReadResourceResult{
+			Contents: []*mcp.ResourceContents{{URI: req.Params.URI, Text: "# Hello"}},
+		}, nil
+	})
+	server.AddPrompt(&mcp.Prompt{
+		Name:        "review",
+		Description: "Review a file",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "file", Required: true},
+			{Name: "focus"},
+		},
+	}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		args := req.Params.Arguments
+		return &mcp.GetPromptResult{
+			Messages: []*mcp.PromptMessage{
+				{Role: "user", Content: &mcp.TextContent{Text: "Review " + args["file"] + " for " + args["focus"]}},
+			},
+		}, nil
+	})
+	return server
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+func withTestMCP(t *testing.T) {
+	t.Helper()
+	eng = engine.New(engine.Config{})
+	saved, savedDir := mcpConnections, mcpConfigDir
+	mcpConnections, mcpConfigDir = nil, ""
+	t.Cleanup(func() {
+		closeMCPConnections()
+		mcpConnections, mcpConfigDir = saved, savedDir
+	})
+}
+
+func TestConnectMCPServer_HTTPTransports(t *testing.T) {
+	server := newTestMCPServer()
+	var gotAuth atomic.Value
+	record := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if a := r.Header.Get("Authorization"); a != "" {
+				gotAuth.Store(a)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+
+	tests := []struct {
+		transport string
+		handler   http.Handler
+	}{
+		{"streamable-http", mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)},
+		{"sse", mcp.NewSSEHandler(func(*http.Request) *mcp.Server { return server }, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			srv := httptest.NewServer(record(tt.handler))
+			t.Cleanup(srv.Close)
+			withTestMCP(t)
+
+			t.Setenv("YAGI_TEST_TOKEN", "secret")
+			gotAuth.Store("")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_, err := connectMCPServer(ctx, "remote", MCPServerConfig{
+				URL:       srv.URL,
+				Transport: tt.transport,
+				Headers:   map[string]string{"Authorization": "Bearer ${YAGI_TEST_TOKEN}"},
+			})
+			if err != nil {
+				t.Fatalf("connectMCPServer: %v", err)
+			}
+			if got := gotAuth.Load(); got != "Bearer secret" {
+				t.Errorf("Authorization header = %q, want %q", got, "Bearer secret")
+			}
+			if got := eng.ExecuteTool(ctx, "add", `{"a":2,"b":3}`); got != "5" {
+				t.Errorf("ExecuteTool(add) = %q, want %q", got, "5")
+			}
+			if getToolSource("add").Origin != "remote" {
+				t.Errorf("tool source = %+v", getToolSource("add"))
+			}
+		})
+	}
+}
+
+func TestNewMCPTransport(t *testing.T) {
+	tr, err := newMCPTransport(MCPServerConfig{Command: "server", Args: []string{"-x"}, Cwd: "/tmp", Env: map[string]string{"FOO": "bar"}})
+	if err != nil {
+		t.Fatalf("newMCPTransport: %v", err)
+	}
+	ct, ok := tr.(*mcp.CommandTransport)
+	if !ok {
+		t.Fatalf("expected CommandTransport, got %T", tr)
+	}
+	if ct.Command.Dir != "/tmp" {
+		t.Errorf("Dir = %q, want /tmp", ct.Command.Dir)
+	}
+	if ct.Command.Env[len(ct.Command.Env)-1] != "FOO=bar" {
+		t.Errorf("expected FOO=bar in env, got %v", ct.Command.Env[len(ct.Command.Env)-1])
+	}
+	if len(ct.Command.Env) != len(os.Environ())+1 {
+		t.Errorf("expected inherited environment plus FOO")
+	}
+
+	tr, err = newMCPTransport(MCPServerConfig{URL: "http://localhost/mcp"})
+	if err != nil {
+		t.Fatalf("newMCPTransport: %v", err)
+	}
+	if _, ok := tr.(*mcp.StreamableClientTransport); !ok {
+		t.Errorf("expected StreamableClientTransport by default for url, got %T", tr)
+	}
+
+	for _, sc := range []MCPServerConfig{
+		{},
+		{Transport: "sse"},
+		{URL: "http://localhost", Transport: "carrier-pigeon"},
+	} {
+		if _, err := newMCPTransport(sc); err == nil {
+			t.Errorf("newMCPTransport(%+v): expected error", sc)
+		}
+	}
+}
+
+func connectTestMCPServer(t *testing.T) {
+	t.Helper()
+	server := newTestMCPServer()
+	srv := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(srv.Close)
+	withTestMCP(t)
+	if _, err := connectMCPServer(context.Background(), "docs", MCPServerConfig{URL: srv.URL}); err != nil {
+		t.Fatalf("connectMCPServer: %v", err)
+	}
+}
+
+func TestAttachMCPResources(t *testing.T) {
+	connectTestMCPServer(t)
+
+	conn := findMCPConnection("docs")
+	if len(conn.resources) != 1 || conn.resources[0].URI != "file:///README.md" {
+		t.Fatalf("resources = %v", conn.resources)
+	}
+
+	got := attachMCPResources(context.Background(), "summarize @docs:file:///README.md and mail @someone:else")
+	if !strings.Contains(got, `<resource server="docs" uri="file:///README.md">`) || !strings.Contains(got, "# Hello") {
+		t.Errorf("expected resource to be attached, got %q", got)
+	}
+	if strings.Count(got, "<resource") != 1 {
+		t.Errorf("expected only known servers to be expanded, got %q", got)
+	}
+
+	handleMCPCommand("resource docs file:///README.md")
+	got = attachMCPResources(context.Background(), "what is this?")
+	if !strings.Contains(got, "# Hello") {
+		t.Errorf("expected pending resource to be attached, got %q", got)
+	}
+	if got := attachMCPResources(context.Background(), "again"); got != "again" {
+		t.Errorf("expected pending resources to be consumed, got %q", got)
+	}
+}
+
+func TestMCPPromptCommand(t *testing.T) {
+	connectTestMCPServer(t)
+
+	c := findMCPPromptCommand("/docs:review")
+	if c == nil {
+		t.Fatal("expected /docs:review command")
+	}
+	values := parsePromptArgs(c.prompt, "main.go focus=errors")
+	if values["file"] != "main.go" || values["focus"] != "errors" {
+		t.Errorf("parsePromptArgs = %v", values)
+	}
+	msgs, err := getMCPPromptMessages(context.Background(), c, values)
+	if err != nil {
+		t.Fatalf("getMCPPromptMessages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Role != "user" || msgs[0].Content != "Review main.go for errors" {
+		t.Errorf("messages = %+v", msgs)
+	}
+}
+
+func TestMCPToolContent(t *testing.T) {
+	connectTestMCPServer(t)
+
+	for _, vision := range []bool{true, false} {
+		llm := newFakeLLM(t,
+			fakeTurn{ToolCalls: []openai.ToolCall{toolCall("snapshot", "{}")}},
+			fakeTurn{Content: "looks fine"},
+		)
+		eng.SetClient(llm.client())
+		eng.SetModel("test")
+
+		var output engine.ToolOutput
+		_, _, err := eng.Chat(context.Background(), engine.UserMessage("snap"), engine.ChatOptions{
+			Vision:       vision,
+			OnToolOutput: func(name string, out engine.ToolOutput) { output = out },
+		})
+		if err != nil {
+			t.Fatalf("Chat: %v", err)
+		}
+
+		for _, want := range []string{"snapshot taken", "<resource uri=\"file:///log.txt\">\nlog line\n</resource>", "[resource link: full <file:///full.png> (image/png)]"} {
+			if !strings.Contains(output.Text, want) {
+				t.Errorf("vision=%v: expected %q in %q", vision, want, output.Text)
+			}
+		}
+		if len(output.Images) != 1 || string(output.Images[0].Data) != "PNGDATA" {
+			t.Errorf("vision=%v: images = %v", vision, output.Images)
+		}
+		if m, ok := output.Structured.(map[string]any); !ok || m["width"] != float64(640) {
+			t.Errorf("vision=%v: structured = %v", vision, output.Structured)
+		}
+
+		msgs := llm.Requests()[1].Messages
+		last := msgs[len(msgs)-1]
+		if vision {
+			if !strings.Contains(output.Text, "[image: image/png, attached]") {
+				t.Errorf("expected attached image placeholder, got %q", output.Text)
+			}
+			if last.Role != "user" || len(last.MultiContent) != 2 || last.MultiContent[1].ImageURL == nil ||
+				last.MultiContent[1].ImageURL.URL != "data:image/png;base64,"+base64.StdEncoding.EncodeToString([]byte("PNGDATA")) {
+				t.Errorf("expected image message after tool results, got %+v", last)
+			}
+		} else {
+			if last.Role != "tool" {
+				t.Errorf("expected no image message without vision, got %+v", last)
+			}
+			i := strings.Index(output.Text, "saved to ")
+			if i < 0 {
+				t.Fatalf("expected image to be saved to a file, got %q", output.Text)
+			}
+			path := strings.TrimSuffix(strings.SplitN(output.Text[i+len("saved to "):], "\n", 2)[0], "]")
+			defer os.Remove(path)
+			if data, err := os.ReadFile(path); err != nil || string(data) != "PNGDATA" {
+				t.Errorf("saved image = %q, %v", data, err)
+			}
+		}
+	}
+}
+
+// TestMain lets the test binary act as a stdio MCP server, so lifecycle tests
+// can start, crash and restart a real server process.
+func TestMain(m *testing.M) {
+	if os.Getenv("YAGI_TEST_MCP_SERVER") == "1" {
+		server := newTestMCPServer()
+		mcp.AddTool(server, &mcp.Tool{Name: "crash", Description: "Exit the server"}, func(ctx context.Context, req *mcp.CallToolRequest, in struct{}) (*mcp.CallToolResult, any, error) {
+			os.Exit(1)
+			return nil, nil, nil
+		})
+		if err := server.Run(context.Background(), &mcp.StdioTransport{}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func stdioTestServer() MCPServerConfig {
+	return MCPServerConfig{Command: os.Args[0], Env: map[string]string{"YAGI_TEST_MCP_SERVER": "1"}}
+}
+
+func TestMCPLifecycle_Reconnect(t *testing.T) {
+	withTestMCP(t)
+	conn, err := connectMCPServer(context.Background(), "local", stdioTestServer())
+	if err != nil {
+		t.Fatalf("connectMCPServer: %v", err)
+	}
+
+	if got := eng.ExecuteTool(context.Background(), "crash", "{}"); !strings.HasPrefix(got, "Error:") {
+		t.Errorf("expected crash to fail, got %q", got)
+	}
+	if got := eng.ExecuteTool(context.Background(), "add", `{"a":1,"b":2}`); got != "3" {
+		t.Errorf("ExecuteTool(add) after crash = %q, want %q", got, "3")
+	}
+	conn.mu.Lock()
+	status := conn.status
+	conn.mu.Unlock()
+	if status != mcpStatusConnected {
+		t.Errorf("status = %q, want %q", status, mcpStatusConnected)
+	}
+}
+
+func TestMCPLifecycle_LazyAndDisabled(t *testing.T) {
+	withTestMCP(t)
+	configDir := t.TempDir()
+	lazy := stdioTestServer()
+	lazy.Lazy = true
+	config, _ := json.Marshal(MCPConfig{MCPServers: map[string]MCPServerConfig{
+		"lazy": lazy,
+		"off":  {Command: "does-not-exist", Disabled: true},
+	}})
+	os.WriteFile(filepath.Join(configDir, "mcp.json"), config, 0o644)
+
+	// Without a cached tool list the lazy server is started once.
+	if err := loadMCPConfig(configDir); err != nil {
+		t.Fatalf("loadMCPConfig: %v", err)
+	}
+	if findMCPConnection("lazy").status != mcpStatusConnected {
+		t.Fatal("expected lazy server to be started when no tools are cached")
+	}
+	if findMCPConnection("off").status != mcpStatusDisabled {
+		t.Error("expected disabled server not to be started")
+	}
+	closeMCPConnections()
+
+	mcpConnections = nil
+	eng = engine.New(engine.Config{})
+	if err := loadMCPConfig(configDir); err != nil {
+		t.Fatalf("loadMCPConfig: %v", err)
+	}
+	conn := findMCPConnection("lazy")
+	if conn.status != mcpStatusStopped || !eng.HasTool("add") {
+		t.Fatalf("expected cached tools without starting the server, status %q", conn.status)
+	}
+	if got := eng.ExecuteTool(context.Background(), "add", `{"a":2,"b":2}`); got != "4" {
+		t.Errorf("ExecuteTool(add) = %q, want %q", got, "4")
+	}
+	conn.mu.Lock()
+	status := conn.status
+	conn.mu.Unlock()
+	if status != mcpStatusConnected {
+		t.Errorf("expected lazy server to start on first use, status %q", status)
+	}
+}
+
+func TestMCPLifecycle_ToolListChangedAndRestart(t *testing.T) {
+	server := newTestMCPServer()
+	srv := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(srv.Close)
+	withTestMCP(t)
+	conn, err := connectMCPServer(context.Background(), "remote", MCPServerConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("connectMCPServer: %v", err)
+	}
+
+	mcp.AddTool(server, &mcp.Tool{Name: "mul", Description: "Multiply two numbers"}, func(ctx context.Context, req *mcp.CallToolRequest, in addInput) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: itoa(in.A * in.B)}}}, nil, nil
+	})
+	server.RemoveTools("snapshot")
+	deadline := time.Now().Add(5 * time.Second)
+	for (!eng.HasTool("mul") || eng.HasTool("snapshot")) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !eng.HasTool("mul") || eng.HasTool("snapshot") {
+		t.Fatal("expected tool list to follow tools/list_changed")
+	}
+
+	conn.mu.Lock()
+	before := conn.session
+	conn.mu.Unlock()
+	if err := conn.restart(context.Background()); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	conn.mu.Lock()
+	after := conn.session
+	conn.mu.Unlock()
+	if after == nil || after == before {
+		t.Error("expected a new session after restart")
+	}
+	if got := eng.ExecuteTool(context.Background(), "mul", `{"a":3,"b":4}`); got != "12" {
+		t.Errorf("ExecuteTool(mul) = %q, want %q", got, "12")
+	}
+}
+
+type recordingApprover struct {
+	mu    sync.Mutex
+	asked []string
+}
+
+func (a *recordingApprover) Approve(ctx context.Context, toolName, args string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.asked = append(a.asked, toolName)
+	return true, nil
+}
+
+func TestMCPToolFilteringAndTrust(t *testing.T) {
+	server := newTestMCPServer()
+	srv := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(srv.Close)
+	withTestMCP(t)
+	approver := &recordingApprover{}
+	eng = engine.New(engine.Config{Approver: approver})
+
+	_, err := connectMCPServer(context.Background(), "pruned", MCPServerConfig{
+		URL:          srv.URL,
+		ExcludeTools: []string{"snap*"},
+		Tools:        map[string]MCPToolConfig{"add": {Trusted: true}},
+	})
+	if err != nil {
+		t.Fatalf("connectMCPServer: %v", err)
+	}
+	if eng.HasTool("snapshot") {
+		t.Error("expected excluded tool not to be registered")
+	}
+	if got := eng.ExecuteTool(context.Background(), "add", `{"a":1,"b":1}`); got != "2" {
+		t.Errorf("ExecuteTool(add) = %q", got)
+	}
+	if len(approver.asked) != 0 {
+		t.Errorf("expected trusted tool to run without approval, asked for %v", approver.asked)
+	}
+
+	eng = engine.New(engine.Config{Approver: approver})
+	_, err = connectMCPServer(context.Background(), "only", MCPServerConfig{
+		URL:          srv.URL,
+		IncludeTools: []string{"snapshot"},
+	})
+	if err != nil {
+		t.Fatalf("connectMCPServer: %v", err)
+	}
+	if eng.HasTool("add") || !eng.HasTool("snapshot") {
+		t.Error("expected only included tools to be registered")
+	}
+	eng.ExecuteTool(context.Background(), "snapshot", "{}")
+	if len(approver.asked) != 1 || approver.asked[0] != "snapshot" {
+		t.Errorf("expected untrusted tool to need approval, asked for %v", approver.asked)
+	}
+}
+
+func TestToolApprover_SkipsWithoutPrompt(t *testing.T) {
+	savedAuto, savedSkip := autonomousMode, skipApproval
+	defer func() { autonomousMode, skipApproval = savedAuto, savedSkip }()
+	autonomousMode, skipApproval = false, false
+
+	setToolSource("plugin_tool", "plugin", "/tmp/plugin_tool.go")
+	a := newToolApprover()
+	if ok, err := a.Approve(context.Background(), "plugin_tool", "{}"); !ok || err != nil {
+		t.Errorf("expected plugin tools not to be asked about, got %v, %v", ok, err)
+	}
+
+	setToolSource("remote_tool", "mcp", "remote")
+	autonomousMode = true
+	if ok, err := a.Approve(context.Background(), "remote_tool", "{}"); !ok || err != nil {
+		t.Errorf("expected autonomous mode to approve, got %v, %v", ok, err)
+	}
+}
+
+func TestDisconnectMCPServer_SharedToolName(t *testing.T) {
+	withTestMCP(t)
+	var conns []*mcpConnection
+	for _, name := range []string{"first", "second"} {
+		srv := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return newTestMCPServer() }, nil))
+		t.Cleanup(srv.Close)
+		conn, err := connectMCPServer(context.Background(), name, MCPServerConfig{URL: srv.URL})
+		if err != nil {
+			t.Fatalf("connectMCPServer(%s): %v", name, err)
+		}
+		t.Cleanup(conn.close)
+		conns = append(conns, conn)
+	}
+	if src := getToolSource("add"); src.Origin != "second" {
+		t.Fatalf("add comes from %s, want the last server", src)
+	}
+
+	// The first server no longer owns add, so leaving must not remove it.
+	disconnectMCPServer(conns[0])
+	if got := eng.ExecuteTool(context.Background(), "add", `{"a":1,"b":2}`); got != "3" {
+		t.Errorf("add after the first server left = %q", got)
+	}
+	disconnectMCPServer(conns[1])
+	if eng.HasTool("add") {
+		t.Error("add is still registered after its server left")
+	}
+}
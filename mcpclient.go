@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// confirmSampling asks before an MCP server uses the model: the editor in
+// STDIO mode, otherwise the terminal, where autonomous mode and -yes answer
+// for the user. Without anyone to ask, the request is declined.
+func confirmSampling(ctx context.Context, req SamplingApproveRequest) bool {
+	if editorPeer != nil {
+		approved, err := editorPeer.approveSampling(ctx, req)
+		if err != nil && !errors.Is(err, errEditorUnavailable) {
+			fmt.Fprintf(os.Stderr, "Warning: sampling request from %s declined: %v\n", req.Server, err)
+		}
+		return approved
+	}
+	if headless {
+		return false
+	}
+	if skipApproval || autonomousMode {
+		return true
+	}
+	return askYesNo(fmt.Sprintf("Let %s use the model? [y/N]: ", req.Server))
+}
+
+// handleSampling runs a completion requested by the server with the current
+// model.
+func (c *mcpConnection) handleSampling(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+	p := req.Params
+	client, model := c.engine.ClientAndModel()
+	if client == nil {
+		return nil, errors.New("no model is configured")
+	}
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "\n[MCP %s] requests a completion with %s (%d messages, max %d tokens)\n", c.name, model, len(p.Messages), p.MaxTokens)
+	}
+	if !confirmSampling(ctx, SamplingApproveRequest{Server: c.name, Model: model, Messages: len(p.Messages), MaxTokens: p.MaxTokens}) {
+		return nil, errors.New("sampling request declined")
+	}
+
+	var messages []openai.ChatCompletionMessage
+	if p.SystemPrompt != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: p.SystemPrompt})
+	}
+	for _, m := range p.Messages {
+		messages = append(messages, samplingMessage(m))
+	}
+
+	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:       model,
+		Messages:    messages,
+		MaxTokens:   int(p.MaxTokens),
+		Temperature: float32(p.Temperature),
+		Stop:        p.StopSequences,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var text strings.Builder
+	stopReason := "endTurn"
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+		text.WriteString(resp.Choices[0].Delta.Content)
+		if resp.Choices[0].FinishReason == openai.FinishReasonLength {
+			stopReason = "maxTokens"
+		}
+	}
+
+	return &mcp.CreateMessageResult{
+		Content:    &mcp.TextContent{Text: text.String()},
+		Model:      model,
+		Role:       "assistant",
+		StopReason: stopReason,
+	}, nil
+}
+
+func samplingMessage(m *mcp.SamplingMessage) openai.ChatCompletionMessage {
+	role := openai.ChatMessageRoleUser
+	if m.Role == "assistant" {
+		role = openai.ChatMessageRoleAssistant
+	}
+	if img, ok := m.Content.(*mcp.ImageContent); ok {
+		return openai.ChatCompletionMessage{
+			Role: role,
+			MultiContent: []openai.ChatMessagePart{{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL: "data:" + img.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(img.Data),
+				},
+			}},
+		}
+	}
+	return openai.ChatCompletionMessage{Role: role, Content: contentToString(context.Background(), []mcp.Content{m.Content})}
+}
+
+// handleElicitation asks the user for the input the server wants: on the
+// terminal, or through the editor in STDIO mode. Without anyone to ask, the
+// request is cancelled.
+func (c *mcpConnection) handleElicitation(ctx context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+	if editorPeer != nil {
+		return editorPeer.elicit(ctx, c.name, req.Params)
+	}
+	if headless {
+		return &mcp.ElicitResult{Action: "cancel"}, nil
+	}
+	return elicitFromTTY(c.name, req.Params)
+}
+
+type elicitField struct {
+	Name        string
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Enum        []any  `json:"enum"`
+	Required    bool
+}
+
+// elicitationFields returns the properties of a requested schema in the
+// order the server listed them.
+func elicitationFields(schema any) ([]elicitField, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	var s struct {
+		Properties map[string]elicitField `json:"properties"`
+		Required   []string               `json:"required"`
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Properties json.RawMessage `json:"properties"`
+	}
+	json.Unmarshal(data, &raw)
+	names, err := objectKeys(raw.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []elicitField
+	for _, name := range names {
+		f := s.Properties[name]
+		f.Name = name
+		for _, r := range s.Required {
+			if r == name {
+				f.Required = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func objectKeys(obj json.RawMessage) ([]string, error) {
+	if len(obj) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func convertElicitValue(f elicitField, s string) (any, error) {
+	if len(f.Enum) > 0 {
+		for _, e := range f.Enum {
+			if fmt.Sprint(e) == s {
+				return e, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %v", f.Enum)
+	}
+	switch f.Type {
+	case "number":
+		return strconv.ParseFloat(s, 64)
+	case "integer":
+		return strconv.ParseInt(s, 10, 64)
+	case "boolean":
+		switch strings.ToLower(s) {
+		case "y", "yes", "true":
+			return true, nil
+		case "n", "no", "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("answer yes or no")
+	}
+	return s, nil
+}
+
+func elicitFromTTY(server string, p *mcp.ElicitParams) (*mcp.ElicitResult, error) {
+	fmt.Fprintf(os.Stderr, "\n[MCP %s] %s\n", server, p.Message)
+	if p.Mode == "url" {
+		fmt.Fprintf(os.Stderr, "  %s\n", p.URL)
+		if !askYesNo("Open this URL to continue? [y/N]: ") {
+			return &mcp.ElicitResult{Action: "decline"}, nil
+		}
+		return &mcp.ElicitResult{Action: "accept"}, nil
+	}
+
+	if !askYesNo("Provide this information? [y/N]: ") {
+		return &mcp.ElicitResult{Action: "decline"}, nil
+	}
+	fields, err := elicitationFields(p.RequestedSchema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid requested schema: %w", err)
+	}
+
+	content := map[string]any{}
+	for _, f := range fields {
+		label := f.Name
+		if f.Title != "" {
+			label = f.Title
+		}
+		if f.Description != "" {
+			label += " (" + f.Description + ")"
+		}
+		if len(f.Enum) > 0 {
+			label += fmt.Sprintf(" %v", f.Enum)
+		}
+		if !f.Required {
+			label += " [optional]"
+		}
+		for {
+			answer, err := readFromTTY(label + ": ")
+			if err != nil {
+				return &mcp.ElicitResult{Action: "cancel"}, nil
+			}
+			answer = strings.TrimSpace(answer)
+			if answer == "" && !f.Required {
+				break
+			}
+			if answer == "" {
+				fmt.Fprintln(os.Stderr, "A value is required.")
+				continue
+			}
+			v, err := convertElicitValue(f, answer)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid value: %v\n", err)
+				continue
+			}
+			content[f.Name] = v
+			break
+		}
+	}
+	return &mcp.ElicitResult{Action: "accept", Content: content}, nil
+}
+
+func askYesNo(prompt string) bool {
+	response, err := readFromTTY(prompt)
+	if err != nil {
+		return false
+	}
+	response = strings.TrimSpace(strings.ToLower(response))
+	return response == "y" || response == "yes"
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// connectClientTestServer connects a server whose tools call back into the
+// client, the way servers use sampling and elicitation.
+func connectClientTestServer(t *testing.T) {
+	t.Helper()
+	server := mcp.NewServer(&mcp.Implementation{Name: "callback", Version: "1.0.0"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "summarize", Description: "Summarize using the client's model"}, func(ctx context.Context, req *mcp.CallToolRequest, in struct{}) (*mcp.CallToolResult, any, error) {
+		res, err := req.Session.CreateMessage(ctx, &mcp.CreateMessageParams{
+			SystemPrompt: "Be brief.",
+			Messages:     []*mcp.SamplingMessage{{Role: "user", Content: &mcp.TextContent{Text: "Summarize this"}}},
+			MaxTokens:    100,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{res.Content}}, nil, nil
+	})
+	mcp.AddTool(server, &mcp.Tool{Name: "ask", Description: "Ask the user for their name"}, func(ctx context.Context, req *mcp.CallToolRequest, in struct{}) (*mcp.CallToolResult, any, error) {
+		res, err := req.Session.Elicit(ctx, &mcp.ElicitParams{
+			Message: "Who are you?",
+			RequestedSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"name": map[string]any{"type": "string"}},
+			},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: fmt.Sprintf("%s %v", res.Action, res.Content["name"])}}}, nil, nil
+	})
+	srv := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(srv.Close)
+	withTestMCP(t)
+	if _, err := connectMCPServer(context.Background(), "callback", MCPServerConfig{URL: srv.URL}); err != nil {
+		t.Fatalf("connectMCPServer: %v", err)
+	}
+}
+
+func TestMCPSampling(t *testing.T) {
+	connectClientTestServer(t)
+	saved := skipApproval
+	skipApproval = true
+	defer func() { skipApproval = saved }()
+
+	llm := newFakeLLM(t, fakeTurn{Content: "short summary"})
+	eng.SetClient(llm.client())
+	eng.SetModel("test")
+
+	out, isErr := eng.CallTool(context.Background(), "summarize", "{}")
+	if isErr || out != "short summary" {
+		t.Fatalf("summarize = %q (error %v)", out, isErr)
+	}
+	reqs := llm.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected one completion, got %d", len(reqs))
+	}
+	msgs := reqs[0].Messages
+	if reqs[0].Model != "test" || reqs[0].MaxTokens != 100 || len(msgs) != 2 ||
+		msgs[0].Role != "system" || msgs[0].Content != "Be brief." || msgs[1].Content != "Summarize this" {
+		t.Errorf("unexpected sampling request: %+v", reqs[0])
+	}
+}
+
+func TestMCPSampling_Declined(t *testing.T) {
+	connectClientTestServer(t)
+	savedSkip, savedHeadless := skipApproval, headless
+	skipApproval, headless = true, true
+	defer func() { skipApproval, headless = savedSkip, savedHeadless }()
+	llm := newFakeLLM(t)
+	eng.SetClient(llm.client())
+
+	// -yes does not approve when there is no terminal.
+	if _, isErr := eng.CallTool(context.Background(), "summarize", "{}"); !isErr {
+		t.Error("headless: expected sampling to be declined")
+	}
+	if out, _ := eng.CallTool(context.Background(), "ask", "{}"); out != "cancel <nil>" {
+		t.Errorf("headless: ask = %q, want the elicitation cancelled", out)
+	}
+
+	pr, pw := io.Pipe()
+	savedPeer, savedOut := editorPeer, stdioOut
+	editorPeer, stdioOut = newSTDIOPeer(), pw
+	defer func() { editorPeer, stdioOut = savedPeer, savedOut }()
+	editorPeer.jsonrpc = true
+	editorPeer.clientCaps = map[string]json.RawMessage{"sampling": json.RawMessage(`{}`)}
+	go func() {
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			var req JSONRPCRequest
+			json.Unmarshal(scanner.Bytes(), &req)
+			var params SamplingApproveRequest
+			json.Unmarshal(req.Params, &params)
+			if req.Method != "sampling/approve" || params.Server != "callback" || params.MaxTokens != 100 {
+				t.Errorf("unexpected editor request: %s", scanner.Text())
+			}
+			editorPeer.deliver(`{"jsonrpc":"2.0","id":"` + fmt.Sprint(req.ID) + `","result":{"approved":false}}`)
+		}
+	}()
+
+	if _, isErr := eng.CallTool(context.Background(), "summarize", "{}"); !isErr {
+		t.Error("editor: expected sampling to be declined")
+	}
+	pw.Close()
+	if n := len(llm.Requests()); n != 0 {
+		t.Errorf("declined sampling reached the model %d times", n)
+	}
+}
+
+func TestMCPElicitation_Editor(t *testing.T) {
+	connectClientTestServer(t)
+
+	pr, pw := io.Pipe()
+	savedPeer, savedOut := editorPeer, stdioOut
+	editorPeer, stdioOut = newSTDIOPeer(), pw
+	defer func() { editorPeer, stdioOut = savedPeer, savedOut }()
+
+	// Line-delimited editors cannot answer requests.
+	if out, _ := eng.CallTool(context.Background(), "ask", "{}"); out != "cancel <nil>" {
+		t.Errorf("without JSON-RPC: ask = %q", out)
+	}
+
+	editorPeer.jsonrpc = true
+	editorPeer.clientCaps = map[string]json.RawMessage{"elicitation": json.RawMessage(`{}`)}
+	go func() {
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			var req JSONRPCRequest
+			json.Unmarshal(scanner.Bytes(), &req)
+			var params map[string]any
+			json.Unmarshal(req.Params, &params)
+			if req.Method != "elicitation/create" || params["server"] != "callback" || params["message"] != "Who are you?" {
+				t.Errorf("unexpected editor request: %s", scanner.Text())
+			}
+			resp, _ := json.Marshal(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"result":  map[string]any{"action": "accept", "content": map[string]any{"name": "gopher"}},
+			})
+			if !editorPeer.deliver(string(resp)) {
+				t.Errorf("response %s was not delivered", resp)
+			}
+		}
+	}()
+
+	if out, _ := eng.CallTool(context.Background(), "ask", "{}"); out != "accept gopher" {
+		t.Errorf("ask = %q", out)
+	}
+	pw.Close()
+}
+
+func TestElicitationFields(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object","properties":{
+		"name":{"type":"string","title":"Your name"},
+		"age":{"type":"integer"},
+		"color":{"type":"string","enum":["red","blue"]},
+		"subscribe":{"type":"boolean"}
+	},"required":["name","age"]}`)
+	fields, err := elicitationFields(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	if fmt.Sprint(names) != "[name age color subscribe]" {
+		t.Fatalf("fields out of order: %v", names)
+	}
+	if !fields[0].Required || !fields[1].Required || fields[2].Required || fields[0].Title != "Your name" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+
+	tests := []struct {
+		field   elicitField
+		input   string
+		want    any
+		wantErr bool
+	}{
+		{fields[1], "42", int64(42), false},
+		{fields[1], "forty", nil, true},
+		{fields[2], "blue", "blue", false},
+		{fields[2], "green", nil, true},
+		{fields[3], "yes", true, false},
+		{fields[3], "maybe", nil, true},
+		{elicitField{Type: "number"}, "1.5", 1.5, false},
+	}
+	for _, tt := range tests {
+		got, err := convertElicitValue(tt.field, tt.input)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("convertElicitValue(%s, %q) = %v, %v", tt.field.Name, tt.input, got, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// pendingMCPResources holds resources attached with /mcp resource; they are
+// sent along with the next user message.
+var pendingMCPResources []string
+
+var mcpMentionRe = regexp.MustCompile(`@([A-Za-z0-9_.-]+):(\S+)`)
+
+func readMCPResource(ctx context.Context, server, uri string) (string, error) {
+	conn := findMCPConnection(server)
+	if conn == nil {
+		return "", fmt.Errorf("unknown MCP server: %s", server)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	session, err := conn.getSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	res, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: uri})
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, c := range res.Contents {
+		if c.Text != "" {
+			sb.WriteString(c.Text)
+		} else if len(c.Blob) > 0 {
+			fmt.Fprintf(&sb, "[binary content: %s, %d bytes]", c.MIMEType, len(c.Blob))
+		}
+	}
+	return fmt.Sprintf("<resource server=%q uri=%q>\n%s\n</resource>", server, uri, sb.String()), nil
+}
+
+// attachMCPResources appends pending resources and resources mentioned as
+// @server:uri to the user input. Mentions of unknown servers are left as is.
+func attachMCPResources(ctx context.Context, input string) string {
+	attachments := pendingMCPResources
+	pendingMCPResources = nil
+
+	for _, m := range mcpMentionRe.FindAllStringSubmatch(input, -1) {
+		if findMCPConnection(m[1]) == nil {
+			continue
+		}
+		text, err := readMCPResource(ctx, m[1], m[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to read %s: %v\n", m[0], err)
+			continue
+		}
+		attachments = append(attachments, text)
+	}
+	if len(attachments) == 0 {
+		return input
+	}
+	return input + "\n\n" + strings.Join(attachments, "\n\n")
+}
+
+type mcpPromptCommand struct {
+	Name   string
+	conn   *mcpConnection
+	prompt *mcp.Prompt
+}
+
+// mcpPromptCommands returns the prompts of all servers as /server:prompt
+// slash commands.
+func mcpPromptCommands() []mcpPromptCommand {
+	var cmds []mcpPromptCommand
+	for _, conn := range listMCPConnections() {
+		conn.mu.Lock()
+		for _, p := range conn.prompts {
+			cmds = append(cmds, mcpPromptCommand{Name: "/" + conn.name + ":" + p.Name, conn: conn, prompt: p})
+		}
+		conn.mu.Unlock()
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+func findMCPPromptCommand(name string) *mcpPromptCommand {
+	for _, c := range mcpPromptCommands() {
+		if c.Name == name {
+			return &c
+		}
+	}
+	return nil
+}
+
+// parsePromptArgs parses "key=value" pairs. A bare value is assigned to the
+// first argument that has not been set yet.
+func parsePromptArgs(p *mcp.Prompt, args string) map[string]string {
+	values := map[string]string{}
+	var positional []string
+	for _, f := range strings.Fields(args) {
+		if k, v, ok := strings.Cut(f, "="); ok {
+			values[k] = v
+		} else {
+			positional = append(positional, f)
+		}
+	}
+	for _, a := range p.Arguments {
+		if len(positional) == 0 {
+			break
+		}
+		if _, ok := values[a.Name]; !ok {
+			values[a.Name] = positional[0]
+			positional = positional[1:]
+		}
+	}
+	if len(positional) > 0 && len(p.Arguments) > 0 {
+		last := p.Arguments[len(p.Arguments)-1].Name
+		values[last] = strings.TrimSpace(values[last] + " " + strings.Join(positional, " "))
+	}
+	return values
+}
+
+// fillPromptArgs asks for arguments that were not given on the command line.
+func fillPromptArgs(p *mcp.Prompt, values map[string]string) error {
+	for _, a := range p.Arguments {
+		if _, ok := values[a.Name]; ok {
+			continue
+		}
+		label := a.Name
+		if a.Description != "" {
+			label += " (" + a.Description + ")"
+		}
+		if !a.Required {
+			label += " [optional]"
+		}
+		v, err := readFromTTY(label + ": ")
+		if err != nil {
+			return err
+		}
+		v = strings.TrimSpace(v)
+		if v == "" {
+			if a.Required {
+				return fmt.Errorf("argument %q is required", a.Name)
+			}
+			continue
+		}
+		values[a.Name] = v
+	}
+	return nil
+}
+
+func getMCPPromptMessages(ctx context.Context, c *mcpPromptCommand, values map[string]string) ([]openai.ChatCompletionMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	session, err := c.conn.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := session.GetPrompt(ctx, &mcp.GetPromptParams{Name: c.prompt.Name, Arguments: values})
+	if err != nil {
+		return nil, err
+	}
+	var msgs []openai.ChatCompletionMessage
+	for _, m := range res.Messages {
+		role := openai.ChatMessageRoleUser
+		if m.Role == "assistant" {
+			role = openai.ChatMessageRoleAssistant
+		}
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    role,
+			Content: contentToString(ctx, []mcp.Content{m.Content}),
+		})
+	}
+	return msgs, nil
+}
+
+func runMCPPromptCommand(c *mcpPromptCommand, args string, messages *[]openai.ChatCompletionMessage, skill string) {
+	values := parsePromptArgs(c.prompt, args)
+	if err := fillPromptArgs(c.prompt, values); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	msgs, err := getMCPPromptMessages(context.Background(), c, values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if len(msgs) == 0 {
+		fmt.Println("Prompt returned no messages.")
+		return
+	}
+	*messages = append(*messages, msgs...)
+	runChat(messages, skill)
+	fmt.Println()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// mcpServerMode is set by -mcp-server.
+var mcpServerMode bool
+
+type chatToolInput struct {
+	Prompt string `json:"prompt" jsonschema:"the message to send to yagi"`
+	Skill  string `json:"skill,omitempty" jsonschema:"name of a skill to use"`
+}
+
+type chatToolOutput struct {
+	Content string `json:"content"`
+}
+
+// newYagiMCPServer exposes the engine's tools, plus a chat tool running the
+// full chat loop, as an MCP server.
+func newYagiMCPServer() *mcp.Server {
+	server := mcp.NewServer(&mcp.Implementation{Name: name, Version: version}, nil)
+
+	for _, t := range eng.Tools() {
+		toolName := t.Function.Name
+		schema, err := json.Marshal(t.Function.Parameters)
+		if err != nil || !isObjectSchema(schema) {
+			fmt.Fprintf(os.Stderr, "Warning: not serving tool %s: parameters are not an object schema\n", toolName)
+			continue
+		}
+		server.AddTool(&mcp.Tool{
+			Name:        toolName,
+			Description: t.Function.Description,
+			InputSchema: json.RawMessage(schema),
+		}, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := string(req.Params.Arguments)
+			if args == "" {
+				args = "{}"
+			}
+			result, isErr := eng.CallTool(ctx, toolName, args)
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{&mcp.TextContent{Text: result}},
+				IsError: isErr,
+			}, nil
+		})
+	}
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "chat",
+		Description: "Ask yagi to carry out a task with its own tools and return the final answer",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, in chatToolInput) (*mcp.CallToolResult, chatToolOutput, error) {
+		if in.Skill != "" {
+			if _, ok := skillPrompts[in.Skill]; !ok {
+				return nil, chatToolOutput{}, fmt.Errorf("unknown skill: %s", in.Skill)
+			}
+		}
+		opts := engine.ChatOptions{
+			Skill:      in.Skill,
+			Autonomous: true,
+		}
+		pinActiveModel(&opts)
+		content, _, err := eng.Chat(ctx, engine.UserMessage(in.Prompt), opts)
+		if err != nil {
+			return nil, chatToolOutput{}, err
+		}
+		return nil, chatToolOutput{Content: content}, nil
+	})
+
+	return server
+}
+
+func isObjectSchema(schema []byte) bool {
+	var m map[string]any
+	if err := json.Unmarshal(schema, &m); err != nil {
+		return false
+	}
+	return m["type"] == "object"
+}
+
+// isLoopbackAddr reports whether addr only listens on the local machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newMCPHTTPHandler serves server over streamable HTTP. If token is set,
+// requests must carry it as a bearer token.
+func newMCPHTTPHandler(server *mcp.Server, token string) http.Handler {
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	return requireBearer(token, handler, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+	})
+}
+
+// runMCPServerMode serves yagi over stdio, or over streamable HTTP when addr
+// is set. Over HTTP, YAGI_SERVE_TOKEN is required unless addr is a loopback
+// address, since the chat tool runs yagi's tools without approval.
+func runMCPServerMode(addr string) error {
+	server := newYagiMCPServer()
+	if addr == "" {
+		return server.Run(context.Background(), &mcp.StdioTransport{})
+	}
+	token := os.Getenv("YAGI_SERVE_TOKEN")
+	if token == "" && !isLoopbackAddr(addr) {
+		return fmt.Errorf("-mcp-http on %s needs YAGI_SERVE_TOKEN; set it or listen on a loopback address such as 127.0.0.1:8081", addr)
+	}
+	fmt.Fprintf(os.Stderr, "Serving MCP on http://%s\n", addr)
+	return http.ListenAndServe(addr, newMCPHTTPHandler(server, token))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+func TestYagiMCPServer(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{Content: "the answer"})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	eng.RegisterTool("shout", "Shout", json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}}}`),
+		func(ctx context.Context, args string) (string, error) {
+			var in struct{ Text string }
+			json.Unmarshal([]byte(args), &in)
+			if in.Text == "" {
+				return "", errors.New("nothing to shout")
+			}
+			return in.Text + "!", nil
+		}, true)
+
+	ctx := context.Background()
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	server := newYagiMCPServer()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverSession.Close()
+	client := mcp.NewClient(&mcp.Implementation{Name: "test", Version: "0"}, nil)
+	session, err := client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	tools := map[string]bool{}
+	for tool, err := range session.Tools(ctx, nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		tools[tool.Name] = true
+	}
+	if !tools["shout"] || !tools["chat"] {
+		t.Fatalf("tools = %v, want shout and chat", tools)
+	}
+
+	res, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "shout", Arguments: map[string]any{"text": "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IsError || contentToString(ctx, res.Content) != "hi!" {
+		t.Errorf("shout = %+v", res)
+	}
+
+	res, err = session.CallTool(ctx, &mcp.CallToolParams{Name: "shout", Arguments: map[string]any{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IsError {
+		t.Errorf("expected tool error to be reported, got %+v", res)
+	}
+
+	res, err = session.CallTool(ctx, &mcp.CallToolParams{Name: "chat", Arguments: map[string]any{"prompt": "question"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.Marshal(res.StructuredContent)
+	if res.IsError || string(out) != `{"content":"the answer"}` {
+		t.Errorf("chat = %s (%+v)", out, res)
+	}
+	reqs := llm.Requests()
+	if len(reqs) != 1 || reqs[0].Messages[len(reqs[0].Messages)-1].Content != "question" {
+		t.Errorf("expected the prompt to reach the model, got %+v", reqs)
+	}
+}
+
+func TestMCPHTTPAuth(t *testing.T) {
+	eng = engine.New(engine.Config{})
+	srv := httptest.NewServer(newMCPHTTPHandler(newYagiMCPServer(), "secret"))
+	defer srv.Close()
+
+	post := func(auth string) int {
+		body := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test","version":"0"}}}`
+		req, _ := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := post(""); got != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d", got)
+	}
+	if got := post("Bearer wrong"); got != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d", got)
+	}
+	if got := post("Bearer secret"); got != http.StatusOK {
+		t.Errorf("right token: status = %d", got)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8081": true,
+		"localhost:8081": true,
+		"[::1]:8081":     true,
+		":8081":          false,
+		"0.0.0.0:8081":   false,
+		"10.0.0.5:8081":  false,
+		"8081":           false,
+	} {
+		if got := isLoopbackAddr(addr); got != want {
+			t.Errorf("isLoopbackAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -5,15 +5,18 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/traefik/yaegi/interp"
 	"github.com/traefik/yaegi/stdlib"
 	"github.com/traefik/yaegi/stdlib/unrestricted"
+	"github.com/yagi-agent/yagi/engine"
 )
 
 var (
@@ -26,6 +29,21 @@ var (
 			"GetMemory":     reflect.ValueOf(getMemoryEntry),
 			"DeleteMemory":  reflect.ValueOf(deleteMemoryEntry),
 			"ListMemory":    reflect.ValueOf(listMemoryEntries),
+			"HTTPRequest":   reflect.ValueOf(httpRequest),
+			"ReadFile":      reflect.ValueOf(sandboxReadFile),
+			"WriteFile":     reflect.ValueOf(sandboxWriteFile),
+			"Glob":          reflect.ValueOf(sandboxGlob),
+			"Exec":          reflect.ValueOf(hostExec),
+			"GetConfig":     reflect.ValueOf(getPluginConfig),
+			"Log":           reflect.ValueOf(pluginLog),
+			"GetState":      reflect.ValueOf(getPluginState),
+			"SetState":      reflect.ValueOf(setPluginState),
+			"DeleteState":   reflect.ValueOf(deletePluginState),
+			"ListState":     reflect.ValueOf(listPluginState),
+			"Progress":      reflect.ValueOf(engine.ReportProgress),
+
+			"HTTPResponse": reflect.ValueOf((*HTTPResponse)(nil)),
+			"ExecResult":   reflect.ValueOf((*ExecResult)(nil)),
 		},
 	}
 	skipApproval    bool
@@ -173,15 +191,19 @@ func loadPlugins(dir, configDir string) error {
 	return nil
 }
 
-func loadPlugin(path, workDir, configDir string, approvals *approvalRecord) error {
-	// Store for later use in executeTool
-	pluginWorkDir = workDir
-	pluginConfigDir = configDir
-	pluginApprovals = approvals
+type pluginTool struct {
+	Name        string
+	Description string
+	Parameters  string
+	Run         reflect.Value
+}
+
+var errNoTool = errors.New("tool.Tool not found")
 
+func newPluginInterp(path string) (*interp.Interpreter, error) {
 	src, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	i := interp.New(interp.Options{})
@@ -191,63 +213,182 @@ func loadPlugin(path, workDir, configDir string, approvals *approvalRecord) erro
 
 	_, err = i.Eval(string(src))
 	if err != nil {
-		return fmt.Errorf("eval: %w", err)
+		return nil, fmt.Errorf("eval: %w", err)
 	}
+	return i, nil
+}
 
+// evalPlugin interprets a plugin source file and extracts its Tool struct.
+func evalPlugin(path string) (*pluginTool, error) {
+	i, err := newPluginInterp(path)
+	if err != nil {
+		return nil, err
+	}
+	return extractTool(i)
+}
+
+func extractTool(i *interp.Interpreter) (*pluginTool, error) {
 	toolVal, err := i.Eval("tool.Tool")
 	if err != nil {
-		return fmt.Errorf("tool.Tool not found: %w", err)
+		return nil, fmt.Errorf("%w: %v", errNoTool, err)
 	}
 
 	v := toolVal.Interface()
 	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("tool.Tool is not a struct")
+	}
 
 	nameField := rv.FieldByName("Name")
 	if !nameField.IsValid() || nameField.Kind() != reflect.String {
-		return fmt.Errorf("Tool.Name field not found or not a string")
+		return nil, fmt.Errorf("Tool.Name field not found or not a string")
 	}
-	name := nameField.String()
 
 	descField := rv.FieldByName("Description")
 	if !descField.IsValid() || descField.Kind() != reflect.String {
-		return fmt.Errorf("Tool.Description field not found or not a string")
+		return nil, fmt.Errorf("Tool.Description field not found or not a string")
 	}
-	description := descField.String()
 
 	paramsField := rv.FieldByName("Parameters")
 	if !paramsField.IsValid() || paramsField.Kind() != reflect.String {
-		return fmt.Errorf("Tool.Parameters field not found or not a string")
+		return nil, fmt.Errorf("Tool.Parameters field not found or not a string")
 	}
-	parameters := paramsField.String()
 
 	runField := rv.FieldByName("Run")
 	if !runField.IsValid() || runField.Kind() != reflect.Func {
-		return fmt.Errorf("Tool.Run field not found or not a function")
+		return nil, fmt.Errorf("Tool.Run field not found or not a function")
+	}
+
+	return &pluginTool{
+		Name:        nameField.String(),
+		Description: descField.String(),
+		Parameters:  paramsField.String(),
+		Run:         runField,
+	}, nil
+}
+
+func loadPlugin(path, workDir, configDir string, approvals *approvalRecord) error {
+	// Store for later use in executeTool
+	pluginWorkDir = workDir
+	pluginConfigDir = configDir
+	pluginApprovals = approvals
+
+	i, err := newPluginInterp(path)
+	if err != nil {
+		return err
 	}
 
-	runFn := convertRunFunc(runField)
-	eng.RegisterTool(name, description, json.RawMessage(parameters), runFn, false)
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Loaded plugin: %s\n", name)
+	// A plugin may define only Commands and/or Hooks without a Tool.
+	pluginName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	pt, toolErr := extractTool(i)
+	if toolErr == nil {
+		pluginName = pt.Name
+	}
+	cmds, err := extractCommands(i, pluginName)
+	if err != nil {
+		return err
+	}
+	hooks, err := extractHooks(i, pluginName)
+	if err != nil {
+		return err
+	}
+	if toolErr != nil && (len(cmds) == 0 && hooks == nil || !errors.Is(toolErr, errNoTool)) {
+		return toolErr
+	}
+
+	if pt != nil {
+		runFn := convertRunFunc(pt.Name, pt.Run)
+		eng.RegisterTool(pt.Name, pt.Description, json.RawMessage(pt.Parameters), runFn, false)
+		setToolSource(pt.Name, "plugin", path)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Loaded plugin: %s\n", pt.Name)
+		}
+	}
+	for _, c := range cmds {
+		if err := registerPluginCommand(c); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: plugin %s: %v\n", pluginName, err)
+			continue
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Loaded plugin command: %s (from %s)\n", c.Name, pluginName)
+		}
+	}
+	if hooks != nil {
+		pluginHooks = append(pluginHooks, hooks)
 	}
 	return nil
 }
 
-func convertRunFunc(runVal reflect.Value) func(context.Context, string) (string, error) {
+func convertRunFunc(name string, runVal reflect.Value) engine.ToolFunc {
 	return func(ctx context.Context, args string) (string, error) {
-		results := runVal.Call([]reflect.Value{
-			reflect.ValueOf(ctx),
-			reflect.ValueOf(args),
-		})
-		if len(results) >= 2 {
-			if err, ok := results[1].Interface().(error); ok && err != nil {
-				return "", err
+		timeout, maxOutput := pluginLimits(name)
+		runCtx, cancel := context.WithTimeout(withPluginName(ctx, name), timeout)
+		defer cancel()
+
+		type runResult struct {
+			output string
+			err    error
+		}
+		// Buffered so that a plugin finishing after the timeout does not leak
+		// a blocked goroutine.
+		ch := make(chan runResult, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					ch <- runResult{err: &engine.ToolError{
+						Kind:    "panic",
+						Tool:    name,
+						Message: fmt.Sprintf("plugin panicked: %v", r),
+					}}
+				}
+			}()
+			output, err := callRunFunc(runVal, runCtx, args)
+			ch <- runResult{output: output, err: err}
+		}()
+
+		select {
+		case r := <-ch:
+			if r.err != nil {
+				return "", r.err
+			}
+			return truncateOutput(r.output, maxOutput), nil
+		case <-runCtx.Done():
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", &engine.ToolError{
+				Kind:    "timeout",
+				Tool:    name,
+				Message: fmt.Sprintf("plugin did not finish within %s", timeout),
 			}
-			return results[0].Interface().(string), nil
 		}
-		if len(results) > 0 {
-			return results[0].Interface().(string), nil
+	}
+}
+
+func callRunFunc(runVal reflect.Value, ctx context.Context, args string) (string, error) {
+	results := runVal.Call([]reflect.Value{
+		reflect.ValueOf(ctx),
+		reflect.ValueOf(args),
+	})
+	if len(results) >= 2 {
+		if err, ok := results[1].Interface().(error); ok && err != nil {
+			return "", err
 		}
-		return "", nil
+		return results[0].Interface().(string), nil
+	}
+	if len(results) > 0 {
+		return results[0].Interface().(string), nil
+	}
+	return "", nil
+}
+
+func truncateOutput(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
 	}
+	return s[:cut] + fmt.Sprintf("\n\n[output truncated: showing %d of %d bytes]", cut, len(s))
 }
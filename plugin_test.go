@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
 )
 
 func TestComputeHash(t *testing.T) {
@@ -227,3 +232,97 @@ func TestLoadApprovalRecords_NonExistent(t *testing.T) {
 		t.Errorf("expected empty directories, got %v", record.Directories)
 	}
 }
+
+func loadInlinePlugin(t *testing.T, name, imports, body string) {
+	t.Helper()
+	src := "package tool\n\nimport (\n\t\"context\"\n" + imports + ")\n\n" +
+		"var Tool = struct {\n\tName string\n\tDescription string\n\tParameters string\n" +
+		"\tRun func(context.Context, string) (string, error)\n}{\n" +
+		"\tName: \"" + name + "\",\n\tDescription: \"test\",\n\tParameters: `{\"type\":\"object\"}`,\n" +
+		"\tRun: func(ctx context.Context, args string) (string, error) {\n" + body + "\n\t},\n}\n"
+	dir := t.TempDir()
+	path := filepath.Join(dir, name+".go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	approvals := &approvalRecord{Directories: make(map[string][]string)}
+	if err := loadPlugin(path, dir, dir, approvals); err != nil {
+		t.Fatalf("loadPlugin: %v", err)
+	}
+}
+
+func TestPluginRun_Panic(t *testing.T) {
+	eng = engine.New(engine.Config{})
+	loadInlinePlugin(t, "panicky", "", `panic("boom")`)
+
+	got := eng.ExecuteTool(context.Background(), "panicky", "{}")
+	if !strings.Contains(got, `"error":"panic"`) || !strings.Contains(got, "boom") {
+		t.Errorf("expected structured panic error, got %q", got)
+	}
+}
+
+func TestPluginRun_Timeout(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig.Plugins = map[string]PluginSettings{"sleepy": {Timeout: 1}}
+
+	eng = engine.New(engine.Config{})
+	loadInlinePlugin(t, "sleepy", "\t\"time\"\n", `time.Sleep(5 * time.Second)
+		return "late", nil`)
+
+	got := eng.ExecuteTool(context.Background(), "sleepy", "{}")
+	if !strings.Contains(got, `"error":"timeout"`) {
+		t.Errorf("expected structured timeout error, got %q", got)
+	}
+}
+
+func TestPluginRun_TruncatesOutput(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig.PluginMaxOutput = 100
+
+	eng = engine.New(engine.Config{})
+	loadInlinePlugin(t, "chatty", "\t\"strings\"\n", `return strings.Repeat("x", 1000), nil`)
+
+	got := eng.ExecuteTool(context.Background(), "chatty", "{}")
+	if !strings.HasPrefix(got, strings.Repeat("x", 100)+"\n") {
+		t.Errorf("expected output truncated to 100 bytes, got %q", got)
+	}
+	if !strings.Contains(got, "[output truncated: showing 100 of 1000 bytes]") {
+		t.Errorf("expected truncation notice, got %q", got)
+	}
+}
+
+func TestTruncateOutput_RuneBoundary(t *testing.T) {
+	got := truncateOutput("ああああ", 4)
+	if !strings.HasPrefix(got, "あ\n") {
+		t.Errorf("expected cut at rune boundary, got %q", got)
+	}
+	if truncateOutput("short", 100) != "short" {
+		t.Error("expected short output to be unchanged")
+	}
+}
+
+func TestPluginRun_Progress(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("crawler", "{}")}},
+		fakeTurn{Content: "finished"},
+	)
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	loadInlinePlugin(t, "crawler", "\t\"hostapi\"\n", `hostapi.Progress(ctx, "page 1")
+		hostapi.Progress(ctx, "page 2")
+		return "2 pages", nil`)
+
+	var progress []string
+	_, _, err := eng.Chat(context.Background(), engine.UserMessage("crawl"), engine.ChatOptions{
+		OnToolProgress: func(name, text string) {
+			progress = append(progress, name+":"+text)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if strings.Join(progress, ",") != "crawler:page 1,crawler:page 2" {
+		t.Errorf("progress = %v", progress)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/traefik/yaegi/interp"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+type pluginCommand struct {
+	Name   string // including the leading "/"
+	Help   string
+	Plugin string
+	Run    engine.ToolFunc
+}
+
+type pluginHookSet struct {
+	Plugin     string
+	OnStart    reflect.Value
+	BeforeTurn reflect.Value
+	AfterTurn  reflect.Value
+	OnExit     reflect.Value
+}
+
+var (
+	pluginCommands []pluginCommand
+	pluginHooks    []*pluginHookSet
+)
+
+// isBuiltinSlashCommand reports whether name is handled by yagi itself.
+// "/exit" is reserved too because /help lists it.
+func isBuiltinSlashCommand(name string) bool {
+	_, ok := slashCommands[name]
+	return ok || name == "/exit"
+}
+
+// extractCommands reads the optional tool.Commands slice of a plugin. Each
+// element must have Name, Help and Run fields; Run has the same signature as
+// Tool.Run and receives the text after the command name.
+func extractCommands(i *interp.Interpreter, plugin string) ([]pluginCommand, error) {
+	v, err := i.Eval("tool.Commands")
+	if err != nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v.Interface())
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("tool.Commands is not a slice")
+	}
+
+	var cmds []pluginCommand
+	for idx := 0; idx < rv.Len(); idx++ {
+		elem := rv.Index(idx)
+		if elem.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("tool.Commands[%d] is not a struct", idx)
+		}
+		nameField := elem.FieldByName("Name")
+		if !nameField.IsValid() || nameField.Kind() != reflect.String || nameField.String() == "" {
+			return nil, fmt.Errorf("Commands[%d].Name field not found or not a string", idx)
+		}
+		helpField := elem.FieldByName("Help")
+		help := ""
+		if helpField.IsValid() && helpField.Kind() == reflect.String {
+			help = helpField.String()
+		}
+		runField := elem.FieldByName("Run")
+		if !runField.IsValid() || runField.Kind() != reflect.Func || runField.IsNil() {
+			return nil, fmt.Errorf("Commands[%d].Run field not found or not a function", idx)
+		}
+		cmds = append(cmds, pluginCommand{
+			Name:   "/" + strings.TrimPrefix(nameField.String(), "/"),
+			Help:   help,
+			Plugin: plugin,
+			Run:    convertRunFunc(plugin, runField),
+		})
+	}
+	return cmds, nil
+}
+
+// extractHooks reads the optional tool.Hooks struct of a plugin. All fields
+// are optional:
+//
+//	OnStart, OnExit       func(context.Context) error
+//	BeforeTurn, AfterTurn func(context.Context, string) (string, error)
+//
+// The turn hooks receive the conversation as a JSON array of messages and may
+// return a replacement array, or "" to leave it unchanged.
+func extractHooks(i *interp.Interpreter, plugin string) (*pluginHookSet, error) {
+	v, err := i.Eval("tool.Hooks")
+	if err != nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v.Interface())
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("tool.Hooks is not a struct")
+	}
+
+	h := &pluginHookSet{Plugin: plugin}
+	for _, f := range []struct {
+		name  string
+		numIn int
+		dst   *reflect.Value
+	}{
+		{"OnStart", 1, &h.OnStart},
+		{"BeforeTurn", 2, &h.BeforeTurn},
+		{"AfterTurn", 2, &h.AfterTurn},
+		{"OnExit", 1, &h.OnExit},
+	} {
+		field := rv.FieldByName(f.name)
+		if !field.IsValid() || (field.Kind() == reflect.Func && field.IsNil()) {
+			continue
+		}
+		if field.Kind() != reflect.Func || field.Type().NumIn() != f.numIn {
+			return nil, fmt.Errorf("Hooks.%s has an unexpected type %s", f.name, field.Type())
+		}
+		*f.dst = field
+	}
+	return h, nil
+}
+
+func registerPluginCommand(c pluginCommand) error {
+	if isBuiltinSlashCommand(c.Name) {
+		return fmt.Errorf("command %s conflicts with a built-in command", c.Name)
+	}
+	if findPluginCommand(c.Name) != nil {
+		return fmt.Errorf("command %s is already defined", c.Name)
+	}
+	pluginCommands = append(pluginCommands, c)
+	return nil
+}
+
+func findPluginCommand(name string) *pluginCommand {
+	for i := range pluginCommands {
+		if pluginCommands[i].Name == name {
+			return &pluginCommands[i]
+		}
+	}
+	return nil
+}
+
+func runPluginSlashCommand(c *pluginCommand, args string) {
+	out, err := c.Run(context.Background(), args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if out != "" {
+		fmt.Println(strings.TrimRight(out, "\n"))
+	}
+}
+
+// callHook runs a hook with the plugin's timeout. Like plugin tools, a hook
+// that ignores ctx is abandoned when the timeout fires.
+func callHook(h *pluginHookSet, kind string, fn reflect.Value, args ...any) ([]reflect.Value, error) {
+	timeout, _ := pluginLimits(h.Plugin)
+	ctx, cancel := context.WithTimeout(withPluginName(context.Background(), h.Plugin), timeout)
+	defer cancel()
+
+	in := []reflect.Value{reflect.ValueOf(ctx)}
+	for _, a := range args {
+		in = append(in, reflect.ValueOf(a))
+	}
+
+	type hookResult struct {
+		results []reflect.Value
+		err     error
+	}
+	// Buffered so that a hook finishing after the timeout does not leak a
+	// blocked goroutine.
+	ch := make(chan hookResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ch <- hookResult{err: fmt.Errorf("%s hook panicked: %v", kind, r)}
+			}
+		}()
+		ch <- hookResult{results: fn.Call(in)}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		for _, v := range r.results {
+			if e, ok := v.Interface().(error); ok && e != nil {
+				return nil, fmt.Errorf("%s hook: %w", kind, e)
+			}
+		}
+		return r.results, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s hook did not finish within %s", kind, timeout)
+	}
+}
+
+func runLifecycleHooks(kind string) {
+	for _, h := range pluginHooks {
+		fn := h.OnStart
+		if kind == "OnExit" {
+			fn = h.OnExit
+		}
+		if !fn.IsValid() {
+			continue
+		}
+		if _, err := callHook(h, kind, fn); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: plugin %s: %v\n", h.Plugin, err)
+		}
+	}
+}
+
+// runTurnHooks passes the conversation through every plugin's BeforeTurn or
+// AfterTurn hook in load order.
+func runTurnHooks(kind string, messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	for _, h := range pluginHooks {
+		fn := h.BeforeTurn
+		if kind == "AfterTurn" {
+			fn = h.AfterTurn
+		}
+		if !fn.IsValid() {
+			continue
+		}
+		b, err := json.Marshal(messages)
+		if err != nil {
+			return messages
+		}
+		results, err := callHook(h, kind, fn, string(b))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: plugin %s: %v\n", h.Plugin, err)
+			continue
+		}
+		if len(results) == 0 {
+			continue
+		}
+		out, _ := results[0].Interface().(string)
+		if strings.TrimSpace(out) == "" {
+			continue
+		}
+		var amended []openai.ChatCompletionMessage
+		if err := json.Unmarshal([]byte(out), &amended); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: plugin %s: %s hook returned invalid messages: %v\n", h.Plugin, kind, err)
+			continue
+		}
+		messages = amended
+	}
+	return messages
+}
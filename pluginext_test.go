@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+const testCommandsPlugin = `package tool
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+var Commands = []struct {
+	Name string
+	Help string
+	Run  func(context.Context, string) (string, error)
+}{
+	{
+		Name: "shout",
+		Help: "Upper-case the arguments",
+		Run: func(ctx context.Context, args string) (string, error) {
+			return strings.ToUpper(args), nil
+		},
+	},
+	{
+		Name: "/model",
+		Help: "Conflicts with a built-in",
+		Run: func(ctx context.Context, args string) (string, error) {
+			return "", nil
+		},
+	},
+	{
+		Name: "/mcp",
+		Help: "Conflicts with a built-in",
+		Run: func(ctx context.Context, args string) (string, error) {
+			return "", nil
+		},
+	},
+	{
+		Name: "/compare",
+		Help: "Conflicts with a built-in",
+		Run: func(ctx context.Context, args string) (string, error) {
+			return "", nil
+		},
+	},
+}
+
+type message struct {
+	Role    string ` + "`json:\"role\"`" + `
+	Content string ` + "`json:\"content,omitempty\"`" + `
+}
+
+var Hooks = struct {
+	BeforeTurn func(context.Context, string) (string, error)
+	AfterTurn  func(context.Context, string) (string, error)
+}{
+	BeforeTurn: func(ctx context.Context, messages string) (string, error) {
+		var msgs []message
+		if err := json.Unmarshal([]byte(messages), &msgs); err != nil {
+			return "", err
+		}
+		msgs = append([]message{{Role: "user", Content: "ticket: OPS-1"}}, msgs...)
+		b, err := json.Marshal(msgs)
+		return string(b), err
+	},
+	AfterTurn: func(ctx context.Context, messages string) (string, error) {
+		panic("after turn")
+	},
+}
+`
+
+func resetPluginExtensions(t *testing.T) {
+	t.Helper()
+	savedCmds, savedHooks := pluginCommands, pluginHooks
+	pluginCommands, pluginHooks = nil, nil
+	t.Cleanup(func() { pluginCommands, pluginHooks = savedCmds, savedHooks })
+}
+
+func loadPluginSource(t *testing.T, name, src string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, name+".go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	approvals := &approvalRecord{Directories: make(map[string][]string)}
+	if err := loadPlugin(path, dir, dir, approvals); err != nil {
+		t.Fatalf("loadPlugin: %v", err)
+	}
+}
+
+func TestPluginCommands(t *testing.T) {
+	resetPluginExtensions(t)
+	eng = engine.New(engine.Config{})
+	loadPluginSource(t, "workflow", testCommandsPlugin)
+
+	if len(eng.Tools()) != 0 {
+		t.Errorf("expected no tools from a commands-only plugin, got %d", len(eng.Tools()))
+	}
+	if len(pluginCommands) != 1 {
+		t.Fatalf("expected 1 command (built-in conflicts skipped), got %d", len(pluginCommands))
+	}
+	c := findPluginCommand("/shout")
+	if c == nil {
+		t.Fatal("expected /shout to be registered")
+	}
+	if c.Help != "Upper-case the arguments" || c.Plugin != "workflow" {
+		t.Errorf("unexpected command: %+v", c)
+	}
+	out, err := c.Run(t.Context(), "hello")
+	if err != nil || out != "HELLO" {
+		t.Errorf("Run = %q, %v", out, err)
+	}
+}
+
+func TestPluginHooks_TurnHooks(t *testing.T) {
+	resetPluginExtensions(t)
+	eng = engine.New(engine.Config{})
+	loadPluginSource(t, "workflow", testCommandsPlugin)
+
+	msgs := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "deploy"}}
+	got := runTurnHooks("BeforeTurn", msgs)
+	if len(got) != 2 || got[0].Content != "ticket: OPS-1" || got[1].Content != "deploy" {
+		t.Errorf("BeforeTurn result = %+v", got)
+	}
+
+	// A panicking hook must leave the conversation untouched.
+	after := runTurnHooks("AfterTurn", got)
+	if len(after) != 2 {
+		t.Errorf("AfterTurn result = %+v", after)
+	}
+}
+
+func TestPluginHooks_Lifecycle(t *testing.T) {
+	resetPluginExtensions(t)
+	setPluginConfigDir(t)
+	eng = engine.New(engine.Config{})
+	loadPluginSource(t, "lifecycle", `package tool
+
+import (
+	"context"
+	"hostapi"
+)
+
+var Hooks = struct {
+	OnStart func(context.Context) error
+	OnExit  func(context.Context) error
+}{
+	OnStart: func(ctx context.Context) error {
+		return hostapi.SetState(ctx, "events", "start")
+	},
+	OnExit: func(ctx context.Context) error {
+		v, _ := hostapi.GetState(ctx, "events")
+		return hostapi.SetState(ctx, "events", v+",exit")
+	},
+}
+`)
+	dir := pluginConfigDir
+	runLifecycleHooks("OnStart")
+	runLifecycleHooks("OnExit")
+
+	data, err := os.ReadFile(filepath.Join(dir, "plugin_data", "lifecycle.json"))
+	if err != nil {
+		t.Fatalf("reading state: %v", err)
+	}
+	if !strings.Contains(string(data), `"start,exit"`) {
+		t.Errorf("state = %s", data)
+	}
+}
+
+func TestPluginHooks_Timeout(t *testing.T) {
+	resetPluginExtensions(t)
+	eng = engine.New(engine.Config{})
+	saved := appConfig
+	appConfig.PluginTimeout = 1
+	defer func() { appConfig = saved }()
+	loadPluginSource(t, "stuck", `package tool
+
+import "context"
+
+var Hooks = struct {
+	BeforeTurn func(context.Context, string) (string, error)
+}{
+	BeforeTurn: func(ctx context.Context, messages string) (string, error) {
+		<-make(chan struct{}) // ignores ctx
+		return "", nil
+	},
+}
+`)
+
+	done := make(chan []openai.ChatCompletionMessage)
+	msgs := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}
+	go func() { done <- runTurnHooks("BeforeTurn", msgs) }()
+	select {
+	case got := <-done:
+		if len(got) != 1 || got[0].Content != "hi" {
+			t.Errorf("BeforeTurn result = %+v", got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("a hook ignoring ctx blocked past its timeout")
+	}
+}
+
+func TestLoadPlugin_NoExports(t *testing.T) {
+	resetPluginExtensions(t)
+	eng = engine.New(engine.Config{})
+	path := filepath.Join(t.TempDir(), "empty.go")
+	os.WriteFile(path, []byte("package tool\n\nvar X = 1\n"), 0o644)
+	approvals := &approvalRecord{Directories: make(map[string][]string)}
+	err := loadPlugin(path, t.TempDir(), t.TempDir(), approvals)
+	if err == nil || !strings.Contains(err.Error(), "tool.Tool not found") {
+		t.Errorf("expected tool.Tool not found error, got %v", err)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type lockedPlugin struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Ref     string `json:"ref,omitempty"`
+	Version string `json:"version,omitempty"`
+	Hash    string `json:"hash"`
+}
+
+type pluginLock struct {
+	Plugins map[string]lockedPlugin `json:"plugins"`
+}
+
+func managedPluginsDir(configDir string) string {
+	return filepath.Join(configDir, "plugins")
+}
+
+func loadPluginLock(configDir string) (*pluginLock, error) {
+	path := filepath.Join(configDir, "plugins.lock.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &pluginLock{Plugins: make(map[string]lockedPlugin)}, nil
+		}
+		return nil, err
+	}
+	var lock pluginLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+	if lock.Plugins == nil {
+		lock.Plugins = make(map[string]lockedPlugin)
+	}
+	return &lock, nil
+}
+
+func savePluginLock(configDir string, lock *pluginLock) error {
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(configDir, "plugins.lock.json")
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// parsePluginSource splits "source[@ref]". An "@" that is followed by a path
+// separator belongs to the source (e.g. git@github.com:user/repo.git).
+func parsePluginSource(arg string) (source, ref string) {
+	idx := strings.LastIndex(arg, "@")
+	if idx <= 0 || strings.ContainsAny(arg[idx+1:], "/:") {
+		return arg, ""
+	}
+	return arg[:idx], arg[idx+1:]
+}
+
+func pluginNameFromSource(source string) string {
+	s := strings.TrimRight(source, "/")
+	if i := strings.LastIndexAny(s, "/:"); i >= 0 {
+		s = s[i+1:]
+	}
+	s = strings.TrimSuffix(s, ".git")
+	s = strings.TrimSuffix(s, ".go")
+	return s
+}
+
+func isRemoteFileSource(source string) bool {
+	return (strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")) &&
+		strings.HasSuffix(source, ".go")
+}
+
+func isGitSource(source string) bool {
+	if isRemoteFileSource(source) {
+		return false
+	}
+	for _, prefix := range []string{"git@", "file://", "ssh://", "git://", "http://", "https://"} {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	if strings.HasSuffix(source, ".git") {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(source, ".git"))
+	return err == nil && fi.IsDir()
+}
+
+func runGit(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// pluginHTTPClient downloads plugin sources, so a stalled host cannot hang
+// an install.
+var pluginHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+// fetchPlugin places the plugin's .go files into dest and returns the fetched
+// version (commit hash for git sources).
+func fetchPlugin(source, ref, dest string) (string, error) {
+	switch {
+	case isRemoteFileSource(source):
+		if ref != "" {
+			return "", fmt.Errorf("ref is not supported for URL sources")
+		}
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return "", err
+		}
+		resp, err := pluginHTTPClient.Get(source)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("fetching %s: %s", source, resp.Status)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		return "", os.WriteFile(filepath.Join(dest, pluginNameFromSource(source)+".go"), b, 0o644)
+	case isGitSource(source):
+		if _, err := runGit("clone", "--quiet", source, dest); err != nil {
+			return "", err
+		}
+		if ref != "" {
+			if _, err := runGit("-C", dest, "checkout", "--quiet", ref); err != nil {
+				return "", err
+			}
+		}
+		version, err := runGit("-C", dest, "rev-parse", "HEAD")
+		if err != nil {
+			return "", err
+		}
+		return version, os.RemoveAll(filepath.Join(dest, ".git"))
+	default:
+		if ref != "" {
+			return "", fmt.Errorf("ref is not supported for local path sources")
+		}
+		fi, err := os.Stat(source)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return "", err
+		}
+		files := []string{source}
+		if fi.IsDir() {
+			files, err = pluginSourceFiles(source)
+			if err != nil {
+				return "", err
+			}
+		}
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(filepath.Join(dest, filepath.Base(f)), b, 0o644); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+}
+
+func pluginSourceFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		n := entry.Name()
+		if entry.IsDir() || filepath.Ext(n) != ".go" || strings.HasSuffix(n, "_test.go") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, n))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func hashPluginDir(dir string) (string, error) {
+	files, err := pluginSourceFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no .go files found in %s", dir)
+	}
+	var buf []byte
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, filepath.Base(f)...)
+		buf = append(buf, 0)
+		buf = append(buf, computeHash(b)...)
+		buf = append(buf, '\n')
+	}
+	return computeHash(buf), nil
+}
+
+// fetchPluginInto fetches into a temporary directory next to the final
+// location and swaps it in only when the fetch succeeded.
+func fetchPluginInto(configDir, name, source, ref string) (*lockedPlugin, error) {
+	base := managedPluginsDir(configDir)
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(base, "."+name+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	staged := filepath.Join(tmp, name)
+	version, err := fetchPlugin(source, ref, staged)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPluginDir(staged)
+	if err != nil {
+		return nil, err
+	}
+
+	dest := filepath.Join(base, name)
+	if err := os.RemoveAll(dest); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		return nil, err
+	}
+	return &lockedPlugin{
+		Name:    name,
+		Source:  source,
+		Ref:     ref,
+		Version: version,
+		Hash:    hash,
+	}, nil
+}
+
+func installPlugin(configDir, arg string) (*lockedPlugin, error) {
+	source, ref := parsePluginSource(arg)
+	if _, err := os.Stat(source); err == nil {
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+	}
+	name := pluginNameFromSource(source)
+	if name == "" || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("cannot determine plugin name from %q", arg)
+	}
+
+	lock, err := loadPluginLock(configDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := lock.Plugins[name]; exists {
+		return nil, fmt.Errorf("plugin %q is already installed (use update)", name)
+	}
+
+	p, err := fetchPluginInto(configDir, name, source, ref)
+	if err != nil {
+		return nil, err
+	}
+	lock.Plugins[name] = *p
+	if err := savePluginLock(configDir, lock); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func updatePlugin(configDir, name string) (*lockedPlugin, error) {
+	lock, err := loadPluginLock(configDir)
+	if err != nil {
+		return nil, err
+	}
+	old, exists := lock.Plugins[name]
+	if !exists {
+		return nil, fmt.Errorf("plugin %q is not installed", name)
+	}
+	p, err := fetchPluginInto(configDir, name, old.Source, old.Ref)
+	if err != nil {
+		return nil, err
+	}
+	lock.Plugins[name] = *p
+	if err := savePluginLock(configDir, lock); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func removePlugin(configDir, name string) error {
+	lock, err := loadPluginLock(configDir)
+	if err != nil {
+		return err
+	}
+	if _, exists := lock.Plugins[name]; !exists {
+		return fmt.Errorf("plugin %q is not installed", name)
+	}
+	if err := os.RemoveAll(filepath.Join(managedPluginsDir(configDir), name)); err != nil {
+		return err
+	}
+	delete(lock.Plugins, name)
+	return savePluginLock(configDir, lock)
+}
+
+func sortedLockedPlugins(lock *pluginLock) []lockedPlugin {
+	names := make([]string, 0, len(lock.Plugins))
+	for n := range lock.Plugins {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]lockedPlugin, 0, len(names))
+	for _, n := range names {
+		result = append(result, lock.Plugins[n])
+	}
+	return result
+}
+
+func loadManagedPlugins(configDir string) error {
+	lock, err := loadPluginLock(configDir)
+	if err != nil {
+		return fmt.Errorf("failed to load plugin lock: %w", err)
+	}
+	if len(lock.Plugins) == 0 {
+		return nil
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	approvals, err := loadApprovalRecords(configDir)
+	if err != nil {
+		return fmt.Errorf("failed to load approval records: %w", err)
+	}
+
+	for _, p := range sortedLockedPlugins(lock) {
+		dir := filepath.Join(managedPluginsDir(configDir), p.Name)
+		hash, err := hashPluginDir(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to verify plugin %s: %v\n", p.Name, err)
+			continue
+		}
+		if hash != p.Hash {
+			fmt.Fprintf(os.Stderr, "Warning: plugin %s does not match plugins.lock.json, skipping (run 'yagi plugin update %s')\n", p.Name, p.Name)
+			continue
+		}
+		files, err := pluginSourceFiles(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to load plugin %s: %v\n", p.Name, err)
+			continue
+		}
+		for _, f := range files {
+			if err := loadPlugin(f, workDir, configDir, approvals); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to load plugin %s: %v\n", f, err)
+			}
+		}
+	}
+	return nil
+}
+
+func runPluginCommand(configDir string, args []string) error {
+	usage := fmt.Errorf("usage: yagi plugin install <git-url|path>[@ref] | list | update [name...] | remove <name...>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "install":
+		if len(args) < 2 {
+			return usage
+		}
+		for _, arg := range args[1:] {
+			p, err := installPlugin(configDir, arg)
+			if err != nil {
+				return fmt.Errorf("install %s: %w", arg, err)
+			}
+			fmt.Printf("Installed %s %s\n", p.Name, shortVersion(p))
+		}
+	case "list":
+		lock, err := loadPluginLock(configDir)
+		if err != nil {
+			return err
+		}
+		if len(lock.Plugins) == 0 {
+			fmt.Println("No plugins installed.")
+			return nil
+		}
+		for _, p := range sortedLockedPlugins(lock) {
+			src := p.Source
+			if p.Ref != "" {
+				src += "@" + p.Ref
+			}
+			fmt.Printf("%-20s %-12s %s\n", p.Name, shortVersion(&p), src)
+		}
+	case "update":
+		names := args[1:]
+		if len(names) == 0 {
+			lock, err := loadPluginLock(configDir)
+			if err != nil {
+				return err
+			}
+			for _, p := range sortedLockedPlugins(lock) {
+				names = append(names, p.Name)
+			}
+		}
+		for _, n := range names {
+			p, err := updatePlugin(configDir, n)
+			if err != nil {
+				return fmt.Errorf("update %s: %w", n, err)
+			}
+			fmt.Printf("Updated %s %s\n", p.Name, shortVersion(p))
+		}
+	case "remove", "uninstall":
+		if len(args) < 2 {
+			return usage
+		}
+		for _, n := range args[1:] {
+			if err := removePlugin(configDir, n); err != nil {
+				return fmt.Errorf("remove %s: %w", n, err)
+			}
+			fmt.Printf("Removed %s\n", n)
+		}
+	default:
+		return usage
+	}
+	return nil
+}
+
+func shortVersion(p *lockedPlugin) string {
+	if len(p.Version) > 12 {
+		return p.Version[:12]
+	}
+	if p.Version == "" {
+		return "-"
+	}
+	return p.Version
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+const testPluginSource = `package tool
+
+import "context"
+
+var Tool = struct {
+	Name        string
+	Description string
+	Parameters  string
+	Run         func(context.Context, string) (string, error)
+}{
+	Name:        "echo_back",
+	Description: "Echo the arguments back",
+	Parameters:  ` + "`" + `{"type":"object","properties":{}}` + "`" + `,
+	Run: func(ctx context.Context, args string) (string, error) {
+		return "VERSION:" + args, nil
+	},
+}
+`
+
+func TestParsePluginSource(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantSource string
+		wantRef    string
+	}{
+		{"https://github.com/user/tools.git", "https://github.com/user/tools.git", ""},
+		{"https://github.com/user/tools.git@v1.2.0", "https://github.com/user/tools.git", "v1.2.0"},
+		{"git@github.com:user/tools.git", "git@github.com:user/tools.git", ""},
+		{"git@github.com:user/tools.git@main", "git@github.com:user/tools.git", "main"},
+		{"./local/dir", "./local/dir", ""},
+		{"file:///tmp/repo@abc123", "file:///tmp/repo", "abc123"},
+	}
+	for _, tt := range tests {
+		source, ref := parsePluginSource(tt.input)
+		if source != tt.wantSource || ref != tt.wantRef {
+			t.Errorf("parsePluginSource(%q) = (%q, %q), want (%q, %q)", tt.input, source, ref, tt.wantSource, tt.wantRef)
+		}
+	}
+}
+
+func TestPluginNameFromSource(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/user/yagi-tools.git": "yagi-tools",
+		"git@github.com:user/mytool.git":         "mytool",
+		"/home/user/tools/":                      "tools",
+		"https://example.com/plugins/weather.go": "weather",
+		"file:///tmp/repo":                       "repo",
+	}
+	for input, want := range tests {
+		if got := pluginNameFromSource(input); got != want {
+			t.Errorf("pluginNameFromSource(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func initTestGitRepo(t *testing.T, content string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := filepath.Join(t.TempDir(), "echo-tools")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, dir, "init", "--quiet")
+	commitTestPlugin(t, dir, content)
+	return dir
+}
+
+func commitTestPlugin(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "echo_back.go"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitRun(t, dir, "add", ".")
+	gitRun(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "update")
+}
+
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := runGit(append([]string{"-C", dir}, args...)...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return out
+}
+
+func TestInstallUpdateRemovePlugin_Git(t *testing.T) {
+	repo := initTestGitRepo(t, testPluginSource)
+	configDir := t.TempDir()
+
+	p, err := installPlugin(configDir, "file://"+repo)
+	if err != nil {
+		t.Fatalf("installPlugin: %v", err)
+	}
+	if p.Name != "echo-tools" {
+		t.Errorf("Name = %q, want %q", p.Name, "echo-tools")
+	}
+	head := gitRun(t, repo, "rev-parse", "HEAD")
+	if p.Version != head {
+		t.Errorf("Version = %q, want %q", p.Version, head)
+	}
+	if _, err := os.Stat(filepath.Join(managedPluginsDir(configDir), "echo-tools", "echo_back.go")); err != nil {
+		t.Fatalf("plugin file not installed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(managedPluginsDir(configDir), "echo-tools", ".git")); !os.IsNotExist(err) {
+		t.Errorf("expected .git to be removed from installed plugin")
+	}
+
+	if _, err := installPlugin(configDir, "file://"+repo); err == nil {
+		t.Error("expected error when installing the same plugin twice")
+	}
+
+	commitTestPlugin(t, repo, strings.Replace(testPluginSource, "VERSION:", "VERSION2:", 1))
+	updated, err := updatePlugin(configDir, "echo-tools")
+	if err != nil {
+		t.Fatalf("updatePlugin: %v", err)
+	}
+	if updated.Version == p.Version {
+		t.Error("expected version to change after update")
+	}
+	if updated.Hash == p.Hash {
+		t.Error("expected hash to change after update")
+	}
+
+	lock, err := loadPluginLock(configDir)
+	if err != nil {
+		t.Fatalf("loadPluginLock: %v", err)
+	}
+	if lock.Plugins["echo-tools"].Hash != updated.Hash {
+		t.Errorf("lock hash = %q, want %q", lock.Plugins["echo-tools"].Hash, updated.Hash)
+	}
+
+	if err := removePlugin(configDir, "echo-tools"); err != nil {
+		t.Fatalf("removePlugin: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(managedPluginsDir(configDir), "echo-tools")); !os.IsNotExist(err) {
+		t.Error("expected plugin directory to be removed")
+	}
+	lock, _ = loadPluginLock(configDir)
+	if _, ok := lock.Plugins["echo-tools"]; ok {
+		t.Error("expected plugin to be removed from lock")
+	}
+}
+
+func TestInstallPlugin_GitRef(t *testing.T) {
+	repo := initTestGitRepo(t, testPluginSource)
+	first := gitRun(t, repo, "rev-parse", "HEAD")
+	commitTestPlugin(t, repo, strings.Replace(testPluginSource, "VERSION:", "VERSION2:", 1))
+
+	configDir := t.TempDir()
+	p, err := installPlugin(configDir, "file://"+repo+"@"+first)
+	if err != nil {
+		t.Fatalf("installPlugin: %v", err)
+	}
+	if p.Version != first || p.Ref != first {
+		t.Errorf("got version %q ref %q, want %q", p.Version, p.Ref, first)
+	}
+	data, _ := os.ReadFile(filepath.Join(managedPluginsDir(configDir), "echo-tools", "echo_back.go"))
+	if strings.Contains(string(data), "VERSION2:") {
+		t.Error("expected the pinned revision to be checked out")
+	}
+}
+
+func TestInstallPlugin_LocalPath(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "local-tools")
+	os.MkdirAll(src, 0o755)
+	os.WriteFile(filepath.Join(src, "echo_back.go"), []byte(testPluginSource), 0o644)
+	os.WriteFile(filepath.Join(src, "echo_back_test.go"), []byte("package tool"), 0o644)
+
+	configDir := t.TempDir()
+	p, err := installPlugin(configDir, src)
+	if err != nil {
+		t.Fatalf("installPlugin: %v", err)
+	}
+	if p.Source != src {
+		t.Errorf("Source = %q, want %q", p.Source, src)
+	}
+	if _, err := os.Stat(filepath.Join(managedPluginsDir(configDir), "local-tools", "echo_back_test.go")); !os.IsNotExist(err) {
+		t.Error("expected _test.go files to be skipped")
+	}
+}
+
+func TestLoadManagedPlugins_VerifiesHash(t *testing.T) {
+	repo := initTestGitRepo(t, testPluginSource)
+	configDir := t.TempDir()
+	if _, err := installPlugin(configDir, "file://"+repo); err != nil {
+		t.Fatalf("installPlugin: %v", err)
+	}
+
+	eng = engine.New(engine.Config{})
+	if err := loadManagedPlugins(configDir); err != nil {
+		t.Fatalf("loadManagedPlugins: %v", err)
+	}
+	if !eng.HasTool("echo_back") {
+		t.Fatal("expected echo_back to be loaded")
+	}
+
+	path := filepath.Join(managedPluginsDir(configDir), "echo-tools", "echo_back.go")
+	os.WriteFile(path, []byte(strings.Replace(testPluginSource, "VERSION:", "TAMPERED:", 1)), 0o644)
+
+	eng = engine.New(engine.Config{})
+	if err := loadManagedPlugins(configDir); err != nil {
+		t.Fatalf("loadManagedPlugins: %v", err)
+	}
+	if eng.HasTool("echo_back") {
+		t.Error("expected tampered plugin to be skipped")
+	}
+}
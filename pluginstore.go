@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Plugin state is kept per plugin in <configDir>/plugin_data/<name>.json.
+// Unlike memory, it is never added to the system prompt.
+
+var pluginStoreMu sync.Mutex
+
+func pluginStorePath(ctx context.Context) (string, error) {
+	name := pluginNameFrom(ctx)
+	if name == "" {
+		return "", fmt.Errorf("plugin state is only available to plugins")
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid plugin name for state storage: %q", name)
+	}
+	if pluginConfigDir == "" {
+		return "", fmt.Errorf("config directory is not available")
+	}
+	return filepath.Join(pluginConfigDir, "plugin_data", name+".json"), nil
+}
+
+func readPluginStore(path string) (map[string]string, error) {
+	data := map[string]string{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return data, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writePluginStore(path string, data map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func getPluginState(ctx context.Context, key string) (string, error) {
+	path, err := pluginStorePath(ctx)
+	if err != nil {
+		return "", err
+	}
+	pluginStoreMu.Lock()
+	defer pluginStoreMu.Unlock()
+	data, err := readPluginStore(path)
+	if err != nil {
+		return "", err
+	}
+	return data[key], nil
+}
+
+func setPluginState(ctx context.Context, key, value string) error {
+	path, err := pluginStorePath(ctx)
+	if err != nil {
+		return err
+	}
+	pluginStoreMu.Lock()
+	defer pluginStoreMu.Unlock()
+	data, err := readPluginStore(path)
+	if err != nil {
+		return err
+	}
+	data[key] = value
+	return writePluginStore(path, data)
+}
+
+func deletePluginState(ctx context.Context, key string) error {
+	path, err := pluginStorePath(ctx)
+	if err != nil {
+		return err
+	}
+	pluginStoreMu.Lock()
+	defer pluginStoreMu.Unlock()
+	data, err := readPluginStore(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := data[key]; !ok {
+		return nil
+	}
+	delete(data, key)
+	return writePluginStore(path, data)
+}
+
+func listPluginState(ctx context.Context) (map[string]string, error) {
+	path, err := pluginStorePath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pluginStoreMu.Lock()
+	defer pluginStoreMu.Unlock()
+	return readPluginStore(path)
+}
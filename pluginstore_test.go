@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+func setPluginConfigDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved := pluginConfigDir
+	pluginConfigDir = dir
+	t.Cleanup(func() { pluginConfigDir = saved })
+	return dir
+}
+
+func TestPluginState_SetGetDeleteList(t *testing.T) {
+	dir := setPluginConfigDir(t)
+	ctx := withPluginName(context.Background(), "bookmarks")
+
+	if err := setPluginState(ctx, "go", "https://go.dev"); err != nil {
+		t.Fatalf("setPluginState: %v", err)
+	}
+	if err := setPluginState(ctx, "yaegi", "https://github.com/traefik/yaegi"); err != nil {
+		t.Fatalf("setPluginState: %v", err)
+	}
+	if got, _ := getPluginState(ctx, "go"); got != "https://go.dev" {
+		t.Errorf("getPluginState = %q", got)
+	}
+	all, err := listPluginState(ctx)
+	if err != nil || len(all) != 2 {
+		t.Errorf("listPluginState = %v, %v", all, err)
+	}
+	if err := deletePluginState(ctx, "go"); err != nil {
+		t.Fatalf("deletePluginState: %v", err)
+	}
+	if got, _ := getPluginState(ctx, "go"); got != "" {
+		t.Errorf("expected deleted key to be empty, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "plugin_data", "bookmarks.json")); err != nil {
+		t.Errorf("expected state file: %v", err)
+	}
+}
+
+func TestPluginState_IsolatedPerPlugin(t *testing.T) {
+	setPluginConfigDir(t)
+	a := withPluginName(context.Background(), "a")
+	b := withPluginName(context.Background(), "b")
+
+	before := len(getAllMemory())
+	setPluginState(a, "key", "from a")
+	if got, _ := getPluginState(b, "key"); got != "" {
+		t.Errorf("plugin b saw plugin a's state: %q", got)
+	}
+	if len(getAllMemory()) != before {
+		t.Error("plugin state must not be written to memory")
+	}
+}
+
+func TestPluginState_RequiresPlugin(t *testing.T) {
+	setPluginConfigDir(t)
+	if err := setPluginState(context.Background(), "k", "v"); err == nil {
+		t.Error("expected error without plugin name")
+	}
+	if err := setPluginState(withPluginName(context.Background(), "../evil"), "k", "v"); err == nil {
+		t.Error("expected error for plugin name with path separators")
+	}
+}
+
+func TestPluginState_FromPlugin(t *testing.T) {
+	eng = engine.New(engine.Config{})
+	loadInlinePlugin(t, "counter", "\t\"hostapi\"\n\t\"strconv\"\n", `v, _ := hostapi.GetState(ctx, "n")
+		n, _ := strconv.Atoi(v)
+		n++
+		if err := hostapi.SetState(ctx, "n", strconv.Itoa(n)); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(n), nil`)
+
+	eng.ExecuteTool(context.Background(), "counter", "{}")
+	if got := eng.ExecuteTool(context.Background(), "counter", "{}"); got != "2" {
+		t.Errorf("second call = %q, want %q", got, "2")
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
 	"github.com/yagi-agent/yagi/provider"
 )
 
@@ -22,3 +27,29 @@ func loadExtraProviders(configDir string) error {
 	providers = result
 	return nil
 }
+
+// newModelClient returns a client and model name for a "provider/model"
+// string without changing the current model. The selected provider reuses
+// the current client; others take their API key from the environment.
+func newModelClient(providerModel string) (*openai.Client, string, error) {
+	providerName, modelName, ok := strings.Cut(providerModel, "/")
+	if !ok {
+		return nil, "", fmt.Errorf("invalid model format %q: use provider/model", providerModel)
+	}
+	p := findProvider(providerName)
+	if p == nil {
+		return nil, "", fmt.Errorf("unknown provider: %s", providerName)
+	}
+	if active, client, _ := activeModel(); active != nil && p.Name == active.Name {
+		return client, modelName, nil
+	}
+
+	var apiKey string
+	if p.EnvKey != "" {
+		apiKey = os.Getenv(p.EnvKey)
+		if apiKey == "" {
+			return nil, "", fmt.Errorf("%s is not set", p.EnvKey)
+		}
+	}
+	return provider.NewClient(p, apiKey), modelName, nil
+}
@@ -28,6 +28,32 @@ func initReadline(prompt, configDir string) error {
 
 	mux = newInputMux(readline.Stdin)
 
+	items := []readline.PrefixCompleterInterface{
+		readline.PcItem("/help"),
+		readline.PcItem("/model", modelItems...),
+		readline.PcItem("/compare"),
+		readline.PcItem("/clear"),
+		readline.PcItem("/memory"),
+		readline.PcItem("/revoke"),
+		readline.PcItem("/agent"),
+		readline.PcItem("/plan"),
+		readline.PcItem("/mode"),
+		readline.PcItem("/mcp",
+			readline.PcItem("status"),
+			readline.PcItem("tools"),
+			readline.PcItem("restart"),
+			readline.PcItem("resources"),
+			readline.PcItem("resource"),
+			readline.PcItem("prompts"),
+		),
+	}
+	for _, c := range pluginCommands {
+		items = append(items, readline.PcItem(c.Name))
+	}
+	for _, c := range mcpPromptCommands() {
+		items = append(items, readline.PcItem(c.Name))
+	}
+
 	cfg := &readline.Config{
 		Prompt:                 prompt,
 		InterruptPrompt:        "^C",
@@ -35,16 +61,7 @@ func initReadline(prompt, configDir string) error {
 		Stderr:                 os.Stderr,
 		Stdin:                  mux,
 		DisableAutoSaveHistory: true,
-		AutoComplete: readline.NewPrefixCompleter(
-			readline.PcItem("/help"),
-			readline.PcItem("/model", modelItems...),
-			readline.PcItem("/clear"),
-			readline.PcItem("/memory"),
-			readline.PcItem("/revoke"),
-			readline.PcItem("/agent"),
-			readline.PcItem("/plan"),
-			readline.PcItem("/mode"),
-		),
+		AutoComplete:           readline.NewPrefixCompleter(items...),
 	}
 	if configDir != "" {
 		cfg.HistoryFile = filepath.Join(configDir, "history")
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// routePlan is the kind of call that generates a plan in planning mode. The
+// engine handles the other kinds.
+const routePlan = "plan"
+
+func modelChoice(name string) (engine.ModelChoice, error) {
+	client, model, err := newModelClient(name)
+	if err != nil {
+		return engine.ModelChoice{}, err
+	}
+	return engine.ModelChoice{Name: name, Client: client, Model: model}, nil
+}
+
+// fallbackChain returns the configured fallbacks of a model. An exact entry
+// wins, then the longest matching pattern, so "anthropic/*" is preferred
+// over "*/*".
+func fallbackChain(name string) []string {
+	if chain, ok := appConfig.Fallbacks[name]; ok {
+		return chain
+	}
+	best := ""
+	for p := range appConfig.Fallbacks {
+		if ok, _ := path.Match(p, name); ok && (len(p) > len(best) || len(p) == len(best) && p < best) {
+			best = p
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return appConfig.Fallbacks[best]
+}
+
+// modelFallbacks is the engine's Fallbacks hook. Models that cannot be used,
+// e.g. for lack of an API key, are skipped; checkRouting warns about them.
+func modelFallbacks(name string) []engine.ModelChoice {
+	var choices []engine.ModelChoice
+	for _, n := range fallbackChain(name) {
+		if n == name {
+			continue
+		}
+		if c, err := modelChoice(n); err == nil {
+			choices = append(choices, c)
+		}
+	}
+	return choices
+}
+
+// routedModel is the engine's Route hook.
+func routedModel(kind string) (engine.ModelChoice, bool) {
+	name := appConfig.Routing[kind]
+	if name == "" {
+		return engine.ModelChoice{}, false
+	}
+	c, err := modelChoice(name)
+	return c, err == nil
+}
+
+// checkRouting warns once about fallbacks and routes that cannot be used.
+func checkRouting() {
+	kinds := make([]string, 0, len(appConfig.Routing))
+	for kind := range appConfig.Routing {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		switch kind {
+		case engine.RouteSummary, engine.RouteTools, routePlan:
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: unknown routing kind %q (use summary, plan or tools)\n", kind)
+			continue
+		}
+		if _, err := modelChoice(appConfig.Routing[kind]); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring %s route: %v\n", kind, err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, chain := range appConfig.Fallbacks {
+		for _, n := range chain {
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			if _, err := modelChoice(n); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring fallback %s: %v\n", n, err)
+			}
+		}
+	}
+}
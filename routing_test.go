@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// setupRoutingTest makes providers point at the given servers and sets the
+// active model to the first one.
+func setupRoutingTest(t *testing.T, urls map[string]string, active string, cfg Config) {
+	t.Helper()
+	savedProviders, savedConfig := providers, appConfig
+	providers = nil
+	for name, url := range urls {
+		providers = append(providers, Provider{Name: name, APIURL: url + "/v1"})
+	}
+	appConfig = cfg
+	eng = engine.New(engine.Config{MaxRetries: 1, Fallbacks: modelFallbacks, Route: routedModel})
+	c, err := modelChoice(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerName, _, _ := strings.Cut(active, "/")
+	setActiveModel(findProvider(providerName), c.Client, c.Model)
+	t.Cleanup(func() {
+		providers, appConfig = savedProviders, savedConfig
+		modelMu.Lock()
+		selectedProvider = nil
+		modelMu.Unlock()
+	})
+}
+
+func TestChat_Fallback(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := newFakeLLM(t, fakeTurn{Content: "from fallback"})
+	setupRoutingTest(t, map[string]string{"down": down.URL, "up": up.srv.URL}, "down/big", Config{
+		Fallbacks: map[string][]string{"down/*": {"missing/x", "up/small"}},
+	})
+
+	var notices []string
+	opts := engine.ChatOptions{OnModel: func(name, reason string) { notices = append(notices, name+" "+reason) }}
+	pinActiveModel(&opts)
+	content, _, err := eng.Chat(context.Background(), engine.UserMessage("hi"), opts)
+	if err != nil || content != "from fallback" {
+		t.Fatalf("Chat = %q, %v", content, err)
+	}
+	if reqs := up.Requests(); len(reqs) != 1 || reqs[0].Model != "small" {
+		t.Errorf("fallback requests = %+v", reqs)
+	}
+	if len(notices) != 1 || !strings.HasPrefix(notices[0], "up/small fallback: down/big failed:") {
+		t.Errorf("notices = %q", notices)
+	}
+}
+
+func TestChat_RouteTools(t *testing.T) {
+	cheap := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("look", "{}")}},
+		fakeTurn{Content: "summary"},
+	)
+	strong := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("look", "{}")}},
+		fakeTurn{Content: "answer"},
+	)
+	setupRoutingTest(t, map[string]string{"cheap": cheap.srv.URL, "strong": strong.srv.URL}, "cheap/mini", Config{
+		Routing: map[string]string{"tools": "strong/max"},
+	})
+	eng.RegisterTool("look", "look", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return "seen", nil
+	}, true)
+
+	var notices []string
+	opts := engine.ChatOptions{OnModel: func(name, reason string) { notices = append(notices, name+" "+reason) }}
+	pinActiveModel(&opts)
+	content, _, err := eng.Chat(context.Background(), engine.UserMessage("hi"), opts)
+	if err != nil || content != "answer" {
+		t.Fatalf("Chat = %q, %v", content, err)
+	}
+	if n := len(cheap.Requests()); n != 1 {
+		t.Errorf("cheap model got %d calls, want only the first", n)
+	}
+	if n := len(strong.Requests()); n != 2 {
+		t.Errorf("strong model got %d calls, want the 2 after tool results", n)
+	}
+	if strings.Join(notices, ",") != "strong/max route: tools" {
+		t.Errorf("notices = %q, want one route notice", notices)
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig.Fallbacks = map[string][]string{
+		"anthropic/*":                {"openrouter/anthropic/claude-sonnet-4.5"},
+		"anthropic/claude-3-5-haiku": {"local/llama"},
+		"*/*":                        {"local/llama"},
+	}
+	for name, want := range map[string]string{
+		"anthropic/claude-3-5-haiku":  "local/llama",
+		"anthropic/claude-sonnet-4-5": "openrouter/anthropic/claude-sonnet-4.5",
+		"openai/gpt-4.1":              "local/llama",
+	} {
+		if got := fallbackChain(name); len(got) != 1 || got[0] != want {
+			t.Errorf("fallbackChain(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// serverModel is the model name that selects yagi's configured model.
+const serverModel = "yagi"
+
+// sessionHeader names a server-side conversation. Requests with the same
+// session ID continue it and only need to send the new messages. Anyone who
+// knows an ID can continue the conversation, so clients should pick
+// unguessable IDs.
+const sessionHeader = "X-Session-ID"
+
+// Sessions idle for serverSessionTTL are forgotten, and at most
+// maxServerSessions are kept.
+var (
+	serverSessionTTL  = time.Hour
+	maxServerSessions = 1000
+)
+
+type chatServer struct {
+	token string // required bearer token, if set
+
+	mu       sync.Mutex
+	sessions map[string]*serverSession
+}
+
+type serverSession struct {
+	mu       sync.Mutex
+	messages []openai.ChatCompletionMessage
+	lastUsed time.Time // guarded by chatServer.mu
+}
+
+type serverError struct {
+	Error serverErrorBody `json:"error"`
+}
+
+type serverErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func newChatServer(token string) *chatServer {
+	return &chatServer{token: token, sessions: make(map[string]*serverSession)}
+}
+
+func (s *chatServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.chatCompletions)
+	mux.HandleFunc("GET /v1/models", s.models)
+	mux.HandleFunc("DELETE /v1/sessions/{id}", s.deleteSession)
+	return s.authorize(mux)
+}
+
+func (s *chatServer) authorize(next http.Handler) http.Handler {
+	return requireBearer(s.token, next, func(w http.ResponseWriter, r *http.Request) {
+		writeServerError(w, http.StatusUnauthorized, "invalid_request_error", "invalid or missing bearer token")
+	})
+}
+
+// requireBearer passes requests carrying token as a bearer token to next and
+// the rest to reject. An empty token lets every request through.
+func requireBearer(token string, next http.Handler, reject http.HandlerFunc) http.Handler {
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			reject(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *chatServer) models(w http.ResponseWriter, r *http.Request) {
+	list := openai.ModelsList{Models: []openai.Model{{ID: serverModel, Object: "model", OwnedBy: name}}}
+	for _, m := range modelList {
+		owner, _, _ := strings.Cut(m.Name, "/")
+		list.Models = append(list.Models, openai.Model{ID: m.Name, Object: "model", OwnedBy: owner})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Object string `json:"object"`
+		openai.ModelsList
+	}{"list", list})
+}
+
+func (s *chatServer) deleteSession(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, ok := s.sessions[r.PathValue("id")]
+	delete(s.sessions, r.PathValue("id"))
+	s.mu.Unlock()
+	if !ok {
+		writeServerError(w, http.StatusNotFound, "invalid_request_error", "unknown session: "+r.PathValue("id"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// session returns the session with id, creating it if needed. It reports
+// false when a new session would exceed maxServerSessions.
+func (s *chatServer) session(id string) (*serverSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, sess := range s.sessions {
+		if now.Sub(sess.lastUsed) > serverSessionTTL {
+			delete(s.sessions, key)
+		}
+	}
+	sess, ok := s.sessions[id]
+	if !ok {
+		if len(s.sessions) >= maxServerSessions {
+			return nil, false
+		}
+		sess = &serverSession{}
+		s.sessions[id] = sess
+	}
+	sess.lastUsed = now
+	return sess, true
+}
+
+func (s *chatServer) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeServerError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeServerError(w, http.StatusBadRequest, "invalid_request_error", "messages is required")
+		return
+	}
+	if len(req.Tools) > 0 || len(req.Functions) > 0 {
+		writeServerError(w, http.StatusBadRequest, "invalid_request_error", "client-side tools are not supported; yagi runs its own tools")
+		return
+	}
+
+	options := RequestOptions{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   max(req.MaxTokens, req.MaxCompletionTokens),
+	}
+	if req.Model != serverModel {
+		options.Model = req.Model
+	}
+	opts := engine.ChatOptions{Autonomous: true}
+	if err := options.apply(&opts); err != nil {
+		writeServerError(w, http.StatusBadRequest, "invalid_request_error", plainError(err).Error())
+		return
+	}
+
+	history := req.Messages
+	var sess *serverSession
+	if id := r.Header.Get(sessionHeader); id != "" {
+		var ok bool
+		if sess, ok = s.session(id); !ok {
+			writeServerError(w, http.StatusTooManyRequests, "invalid_request_error", "too many sessions; delete unused ones or retry later")
+			return
+		}
+		if !sess.mu.TryLock() {
+			writeServerError(w, http.StatusConflict, "invalid_request_error", "session is busy: "+id)
+			return
+		}
+		defer sess.mu.Unlock()
+		history = append(append([]openai.ChatCompletionMessage(nil), sess.messages...), req.Messages...)
+		w.Header().Set(sessionHeader, id)
+	}
+
+	model := req.Model
+	if model == "" {
+		model = serverModel
+	}
+	var usage openai.Usage
+	opts.OnUsage = func(u openai.Usage) {
+		usage.PromptTokens += u.PromptTokens
+		usage.CompletionTokens += u.CompletionTokens
+		usage.TotalTokens += u.TotalTokens
+	}
+	c := completion{id: newCompletionID(), created: time.Now().Unix(), model: model}
+
+	var content string
+	var err error
+	if req.Stream {
+		content, history, err = c.stream(w, r, history, opts, &usage, req.StreamOptions != nil && req.StreamOptions.IncludeUsage)
+	} else {
+		content, history, err = eng.Chat(r.Context(), history, opts)
+		if err != nil {
+			writeServerError(w, http.StatusInternalServerError, "server_error", err.Error())
+		} else {
+			writeJSON(w, http.StatusOK, openai.ChatCompletionResponse{
+				ID:      c.id,
+				Object:  "chat.completion",
+				Created: c.created,
+				Model:   c.model,
+				Choices: []openai.ChatCompletionChoice{{
+					Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+					FinishReason: openai.FinishReasonStop,
+				}},
+				Usage: usage,
+			})
+		}
+	}
+	if err == nil && sess != nil {
+		sess.messages = history
+	}
+}
+
+// completion holds the fields shared by every chunk of one response.
+type completion struct {
+	id      string
+	created int64
+	model   string
+}
+
+func (c completion) chunk(delta openai.ChatCompletionStreamChoiceDelta, finish openai.FinishReason) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finish}},
+	}
+}
+
+// stream runs the chat and sends it as server-sent events. Tools run on the
+// server; only the assistant's text and reasoning are streamed.
+func (c completion) stream(w http.ResponseWriter, r *http.Request, history []openai.ChatCompletionMessage, opts engine.ChatOptions, usage *openai.Usage, includeUsage bool) (string, []openai.ChatCompletionMessage, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(v any) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	send(c.chunk(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, ""))
+	opts.OnContent = func(text string) {
+		send(c.chunk(openai.ChatCompletionStreamChoiceDelta{Content: text}, ""))
+	}
+	opts.OnReasoning = func(text string) {
+		send(c.chunk(openai.ChatCompletionStreamChoiceDelta{ReasoningContent: text}, ""))
+	}
+
+	content, history, err := eng.Chat(r.Context(), history, opts)
+	if err != nil {
+		send(serverError{Error: serverErrorBody{Message: err.Error(), Type: "server_error"}})
+	} else {
+		send(c.chunk(openai.ChatCompletionStreamChoiceDelta{}, openai.FinishReasonStop))
+		if includeUsage {
+			send(openai.ChatCompletionStreamResponse{
+				ID:      c.id,
+				Object:  "chat.completion.chunk",
+				Created: c.created,
+				Model:   c.model,
+				Choices: []openai.ChatCompletionStreamChoice{},
+				Usage:   usage,
+			})
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	return content, history, err
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeServerError(w http.ResponseWriter, status int, typ, message string) {
+	writeJSON(w, status, serverError{Error: serverErrorBody{Message: message, Type: typ}})
+}
+
+// runServeMode serves the OpenAI-compatible API on addr. If
+// YAGI_SERVE_TOKEN is set, requests must carry it as a bearer token. It is
+// required unless addr is a loopback address, since tools run without
+// approval.
+func runServeMode(addr string) error {
+	token := os.Getenv("YAGI_SERVE_TOKEN")
+	if token == "" && !isLoopbackAddr(addr) {
+		return fmt.Errorf("-serve on %s needs YAGI_SERVE_TOKEN; set it or listen on a loopback address such as 127.0.0.1:8080", addr)
+	}
+	fmt.Fprintf(os.Stderr, "Serving OpenAI-compatible API on http://%s/v1\n", addr)
+	return http.ListenAndServe(addr, newChatServer(token).handler())
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+func startChatServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(newChatServer(token).handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func serverClient(srv *httptest.Server, token string) *openai.Client {
+	config := openai.DefaultConfig(token)
+	config.BaseURL = srv.URL + "/v1"
+	return openai.NewClientWithConfig(config)
+}
+
+func TestServer_ChatCompletion(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("lookup", `{"q":"x"}`)}},
+		fakeTurn{Content: "found it"},
+	)
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	var ran bool
+	eng.RegisterTool("lookup", "Look something up", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		ran = true
+		return "x=1", nil
+	}, true)
+	client := serverClient(startChatServer(t, ""), "")
+
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    serverModel,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "look up x"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Error("tool did not run on the server")
+	}
+	if got := resp.Choices[0].Message.Content; got != "found it" {
+		t.Errorf("content = %q", got)
+	}
+	if resp.Usage.TotalTokens != 30 || resp.Model != serverModel {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestServer_Streaming(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{Content: "hello there"})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	client := serverClient(startChatServer(t, ""), "")
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:         serverModel,
+		Messages:      []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var content strings.Builder
+	var finish openai.FinishReason
+	var usage *openai.Usage
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range chunk.Choices {
+			content.WriteString(c.Delta.Content)
+			if c.FinishReason != "" {
+				finish = c.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if content.String() != "hello there" || finish != openai.FinishReasonStop {
+		t.Errorf("content = %q, finish = %q", content.String(), finish)
+	}
+	if usage == nil || usage.TotalTokens != 15 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestServer_Sessions(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{Content: "first"}, fakeTurn{Content: "second"})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	srv := startChatServer(t, "")
+
+	post := func(content string) *http.Response {
+		body := `{"model":"yagi","messages":[{"role":"user","content":"` + content + `"}]}`
+		req, _ := http.NewRequest("POST", srv.URL+"/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set(sessionHeader, "abc")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	post("one")
+	if resp := post("two"); resp.StatusCode != http.StatusOK || resp.Header.Get(sessionHeader) != "abc" {
+		t.Errorf("status = %d, session = %q", resp.StatusCode, resp.Header.Get(sessionHeader))
+	}
+	var roles []string
+	for _, m := range llm.Requests()[1].Messages {
+		roles = append(roles, m.Role+":"+m.Content)
+	}
+	if got := strings.Join(roles, ","); got != "user:one,assistant:first,user:two" {
+		t.Errorf("second request messages = %s", got)
+	}
+
+	req, _ := http.NewRequest("DELETE", srv.URL+"/v1/sessions/abc", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete status = %d", resp.StatusCode)
+	}
+}
+
+func TestServer_AuthAndModels(t *testing.T) {
+	srv := startChatServer(t, "secret")
+
+	_, err := serverClient(srv, "wrong").ListModels(context.Background())
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong token: err = %v", err)
+	}
+
+	models, err := serverClient(srv, "secret").ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models.Models) != len(modelList)+1 || models.Models[0].ID != serverModel {
+		t.Errorf("models = %d, first = %q", len(models.Models), models.Models[0].ID)
+	}
+
+	_, err = serverClient(srv, "secret").CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    serverModel,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+		Tools:    []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "client_tool"}}},
+	})
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusBadRequest {
+		t.Errorf("client tools: err = %v", err)
+	}
+}
+
+func TestServer_SessionLimits(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{Content: "a"}, fakeTurn{Content: "b"})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	savedMax := maxServerSessions
+	maxServerSessions = 1
+	defer func() { maxServerSessions = savedMax }()
+	cs := newChatServer("")
+	srv := httptest.NewServer(cs.handler())
+	defer srv.Close()
+
+	post := func(session string) int {
+		req, _ := http.NewRequest("POST", srv.URL+"/v1/chat/completions", strings.NewReader(`{"model":"yagi","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set(sessionHeader, session)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	post("first")
+	if got := post("second"); got != http.StatusTooManyRequests {
+		t.Errorf("session beyond the cap: status = %d", got)
+	}
+
+	// An idle session expires and makes room.
+	cs.mu.Lock()
+	cs.sessions["first"].lastUsed = time.Now().Add(-serverSessionTTL - time.Minute)
+	cs.mu.Unlock()
+	if got := post("second"); got != http.StatusOK {
+		t.Errorf("after expiry: status = %d", got)
+	}
+	if _, ok := cs.sessions["first"]; ok {
+		t.Error("expired session was kept")
+	}
+}
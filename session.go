@@ -219,7 +219,7 @@ func summarizeMessages(ctx context.Context, client *openai.Client, msgs []openai
 	}
 
 	stream, err := client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
-		Model:    model,
+		Model:    eng.Model(),
 		Messages: summaryMsgs,
 	})
 	if err != nil {
@@ -4,14 +4,33 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/modelcontextprotocol/go-sdk/mcp"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/yagi-agent/yagi/engine"
 )
 
+// stdioProtocolVersion is returned by initialize.
+const stdioProtocolVersion = "1"
+
+// JSON-RPC error codes.
+const (
+	codeParseError       = -32700
+	codeInvalidRequest   = -32600
+	codeMethodNotFound   = -32601
+	codeInvalidParams    = -32602
+	codeInternalError    = -32603
+	codeRequestCancelled = -32800
+)
+
 type JSONRPCRequest struct {
 	JSONRPC string          `json:"jsonrpc"`
 	ID      interface{}     `json:"id"`
@@ -20,92 +39,669 @@ type JSONRPCRequest struct {
 }
 
 type JSONRPCResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	ID      interface{} `json:"id"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   interface{} `json:"error,omitempty"`
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id"`
+	Result  interface{}   `json:"result,omitempty"`
+	Error   *JSONRPCError `json:"error,omitempty"`
+}
+
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	if e.Data != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Data)
+	}
+	return e.Message
 }
 
 type ChatRequest struct {
 	Messages []openai.ChatCompletionMessage `json:"messages"`
 	Stream   bool                           `json:"stream"`
-	Model    string                         `json:"model,omitempty"`
+	Events   int                            `json:"events,omitempty"`
+	RequestOptions
+}
+
+// RequestOptions override the global settings for one request. They are
+// passed to the engine per call, so concurrent requests do not affect each
+// other.
+type RequestOptions struct {
+	Model         string   `json:"model,omitempty"` // provider/model
+	Skill         string   `json:"skill,omitempty"`
+	Temperature   float32  `json:"temperature,omitempty"`
+	TopP          float32  `json:"top_p,omitempty"`
+	MaxTokens     int      `json:"max_tokens,omitempty"`
+	Tools         []string `json:"tools,omitempty"` // allowed tools; [] allows none
+	MaxIterations int      `json:"max_iterations,omitempty"`
 }
 
 type ToolResultResponse struct {
-	Name   string `json:"name"`
-	Output string `json:"output"`
+	Name       string             `json:"name"`
+	Output     string             `json:"output"`
+	Images     []engine.ToolImage `json:"images,omitempty"`
+	Structured any                `json:"structured,omitempty"`
 }
 
 type ChatResponse struct {
-	Content    string              `json:"content,omitempty"`
-	Done       bool                `json:"done,omitempty"`
-	Error      string              `json:"error,omitempty"`
-	ToolResult *ToolResultResponse `json:"tool_result,omitempty"`
+	SessionID    string              `json:"session_id,omitempty"`
+	Content      string              `json:"content,omitempty"`
+	Done         bool                `json:"done,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	ToolResult   *ToolResultResponse `json:"tool_result,omitempty"`
+	ToolProgress *ToolResultResponse `json:"tool_progress,omitempty"`
+}
+
+type JSONRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type InitializeParams struct {
+	ProtocolVersion string                     `json:"protocol_version"`
+	ClientInfo      *ClientInfo                `json:"client_info,omitempty"`
+	Capabilities    map[string]json.RawMessage `json:"capabilities"`
+}
+
+type ClientInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type InitializeResult struct {
+	ProtocolVersion string             `json:"protocol_version"`
+	ServerInfo      ClientInfo         `json:"server_info"`
+	Capabilities    ServerCapabilities `json:"capabilities"`
+}
+
+type ServerCapabilities struct {
+	Methods   []string `json:"methods"`
+	Streaming bool     `json:"streaming"`
+	Sessions  bool     `json:"sessions"`
+	Cancel    bool     `json:"cancel"`
+	Events    []int    `json:"events"`
+}
+
+type ToolApproveRequest struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Source    string `json:"source"`
+}
+
+type ToolApproveResult struct {
+	Approved bool `json:"approved"`
+	Always   bool `json:"always,omitempty"`
+}
+
+type SamplingApproveRequest struct {
+	Server    string `json:"server"`
+	Model     string `json:"model"`
+	Messages  int    `json:"messages"`
+	MaxTokens int64  `json:"max_tokens"`
+}
+
+type SamplingApproveResult struct {
+	Approved bool `json:"approved"`
+}
+
+type SessionPromptRequest struct {
+	SessionID string                         `json:"session_id"`
+	Prompt    string                         `json:"prompt,omitempty"`
+	Messages  []openai.ChatCompletionMessage `json:"messages,omitempty"`
+	Stream    bool                           `json:"stream"`
+	Events    int                            `json:"events,omitempty"`
+	RequestOptions
+}
+
+// stdioOut is where STDIO mode writes; writes are serialized so output from
+// concurrent requests does not interleave.
+var (
+	stdioOutMu sync.Mutex
+	stdioOut   io.Writer = os.Stdout
+)
+
+// stdioAutoApprove is set by -stdio-auto-approve to run tools without asking
+// the editor.
+var stdioAutoApprove bool
+
+// errEditorUnavailable is returned for requests the editor cannot answer.
+var errEditorUnavailable = errors.New("the editor does not accept requests")
+
+// editorPeer is set in STDIO mode and sends requests to the editor, e.g. to
+// ask the user for input on behalf of an MCP server.
+var editorPeer *stdioPeer
+
+// stdioPeer is the connection to the editor in STDIO mode. JSON-RPC requests
+// run concurrently, each with its own context so it can be cancelled.
+type stdioPeer struct {
+	methods       map[string]stdioMethod
+	notifications map[string]func(params json.RawMessage)
+
+	// approve asks the editor about a tool call; nil sends tool/approve.
+	approve func(ctx context.Context, name, args, source string) (ToolApproveResult, error)
+
+	mu         sync.Mutex
+	nextID     int
+	pending    map[string]chan stdioReply
+	closed     bool
+	jsonrpc    bool                       // the editor speaks JSON-RPC and can answer requests
+	clientCaps map[string]json.RawMessage // nil until initialize
+
+	active      map[string]context.CancelFunc
+	sessions    map[string]*stdioSession
+	nextSession int
+	wg          sync.WaitGroup
+}
+
+// stdioSession is a conversation held by yagi between session/prompt
+// requests.
+type stdioSession struct {
+	mu       sync.Mutex
+	messages []openai.ChatCompletionMessage
+	options  RequestOptions // defaults for the session's prompts
+}
+
+type stdioReply struct {
+	Result json.RawMessage `json:"result"`
+	Error  *JSONRPCError   `json:"error"`
+}
+
+type stdioMethod func(p *stdioPeer, ctx context.Context, req JSONRPCRequest) (any, error)
+
+var stdioMethods map[string]stdioMethod
+
+func init() {
+	stdioMethods = map[string]stdioMethod{
+		"initialize":     (*stdioPeer).initialize,
+		"chat":           (*stdioPeer).chat,
+		"session/new":    (*stdioPeer).newSession,
+		"session/prompt": (*stdioPeer).prompt,
+		"session/close":  (*stdioPeer).closeSession,
+	}
+}
+
+func newSTDIOPeer() *stdioPeer {
+	p := &stdioPeer{
+		methods:  stdioMethods,
+		pending:  make(map[string]chan stdioReply),
+		active:   make(map[string]context.CancelFunc),
+		sessions: make(map[string]*stdioSession),
+	}
+	p.notifications = map[string]func(json.RawMessage){
+		"$/cancelRequest": p.cancelRequest,
+	}
+	return p
 }
 
 func runSTDIOMode() error {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
+	editorPeer = newSTDIOPeer()
+	return editorPeer.serve(os.Stdin)
+}
+
+// serve reads requests until r is closed and waits for the running ones to
+// finish.
+func (p *stdioPeer) serve(r io.Reader) error {
+	// Line-delimited requests have no ids, so they are answered in order.
+	lineReqs := make(chan string)
+	lineDone := make(chan struct{})
+	go func() {
+		defer close(lineDone)
+		for line := range lineReqs {
+			handleLineDelimited(line)
 		}
+	}()
 
-		var raw map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &raw); err != nil {
-			writeError("Invalid JSON: " + err.Error())
-			continue
+	var err error
+	reader := bufio.NewReader(r)
+	for {
+		line, rerr := reader.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			p.dispatch(line, lineReqs)
+		}
+		if rerr != nil {
+			if rerr != io.EOF {
+				err = rerr
+			}
+			break
 		}
+	}
 
-		// Detect format
-		if _, hasJSONRPC := raw["jsonrpc"]; hasJSONRPC {
-			handleJSONRPC(line)
+	close(lineReqs)
+	p.mu.Lock()
+	p.closed = true
+	for id, ch := range p.pending {
+		ch <- stdioReply{Error: &JSONRPCError{Code: codeInternalError, Message: "editor closed the connection"}}
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+	<-lineDone
+	p.wg.Wait()
+	return err
+}
+
+func (p *stdioPeer) dispatch(line string, lineReqs chan<- string) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		p.mu.Lock()
+		jsonrpc := p.jsonrpc
+		p.mu.Unlock()
+		if jsonrpc {
+			writeJSONRPCError(nil, &JSONRPCError{Code: codeParseError, Message: "Parse error", Data: err.Error()})
 		} else {
-			handleLineDelimited(line)
+			writeError("Invalid JSON: " + err.Error())
 		}
+		return
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	// Detect format
+	if _, hasJSONRPC := raw["jsonrpc"]; !hasJSONRPC {
+		lineReqs <- line
+		return
 	}
-	return nil
+	if p.deliver(line) {
+		return
+	}
+	p.handleJSONRPC(line)
 }
 
-func handleJSONRPC(line string) {
+func (p *stdioPeer) handleJSONRPC(line string) {
 	var req JSONRPCRequest
 	if err := json.Unmarshal([]byte(line), &req); err != nil {
-		writeJSONRPCError(nil, "Parse error", err.Error())
+		writeJSONRPCError(nil, &JSONRPCError{Code: codeInvalidRequest, Message: "Invalid request", Data: err.Error()})
+		return
+	}
+	p.mu.Lock()
+	p.jsonrpc = true
+	p.mu.Unlock()
+
+	if req.ID == nil {
+		p.handleNotification(req)
+		return
+	}
+	if req.Method == "" {
+		writeJSONRPCError(req.ID, &JSONRPCError{Code: codeInvalidRequest, Message: "Invalid request", Data: "missing method"})
+		return
+	}
+	method, ok := p.methods[req.Method]
+	if !ok {
+		writeJSONRPCError(req.ID, &JSONRPCError{Code: codeMethodNotFound, Message: "Method not found", Data: fmt.Sprintf("Unknown method: %s", req.Method)})
+		return
+	}
+
+	key := requestKey(req.ID)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.mu.Lock()
+	if _, dup := p.active[key]; dup {
+		p.mu.Unlock()
+		cancel()
+		writeJSONRPCError(req.ID, &JSONRPCError{Code: codeInvalidRequest, Message: "Invalid request", Data: "duplicate request id"})
 		return
 	}
+	p.active[key] = cancel
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			p.mu.Lock()
+			delete(p.active, key)
+			p.mu.Unlock()
+			cancel()
+		}()
+
+		result, err := method(p, ctx, req)
+		if err != nil {
+			writeJSONRPCError(req.ID, toJSONRPCError(ctx, err))
+			return
+		}
+		writeJSONRPCResult(req.ID, result)
+	}()
+}
+
+func (p *stdioPeer) handleNotification(req JSONRPCRequest) {
+	// Other notifications, such as initialized, need no action.
+	if fn, ok := p.notifications[req.Method]; ok {
+		fn(req.Params)
+	}
+}
 
-	if req.Method != "chat" {
-		writeJSONRPCError(req.ID, "Method not found", fmt.Sprintf("Unknown method: %s", req.Method))
+func (p *stdioPeer) cancelRequest(params json.RawMessage) {
+	var cancel struct {
+		ID any `json:"id"`
+	}
+	if json.Unmarshal(params, &cancel) != nil || cancel.ID == nil {
 		return
 	}
+	p.mu.Lock()
+	fn := p.active[requestKey(cancel.ID)]
+	p.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// requestKey keys requests by the JSON form of their id, so 1 and "1" stay
+// distinct.
+func requestKey(id any) string {
+	b, _ := json.Marshal(id)
+	return string(b)
+}
+
+func toJSONRPCError(ctx context.Context, err error) *JSONRPCError {
+	var rpcErr *JSONRPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	if ctx.Err() != nil {
+		return &JSONRPCError{Code: codeRequestCancelled, Message: "Request cancelled"}
+	}
+	return &JSONRPCError{Code: codeInternalError, Message: "Chat error", Data: err.Error()}
+}
+
+func invalidParams(err error) error {
+	return &JSONRPCError{Code: codeInvalidParams, Message: "Invalid params", Data: err.Error()}
+}
+
+func (p *stdioPeer) initialize(ctx context.Context, req JSONRPCRequest) (any, error) {
+	var params InitializeParams
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+	}
+	if params.Capabilities == nil {
+		params.Capabilities = map[string]json.RawMessage{}
+	}
+	p.mu.Lock()
+	p.clientCaps = params.Capabilities
+	p.mu.Unlock()
 
+	res := InitializeResult{
+		ProtocolVersion: stdioProtocolVersion,
+		ServerInfo:      ClientInfo{Name: name, Version: version},
+		Capabilities:    ServerCapabilities{Streaming: true, Sessions: true, Cancel: true, Events: []int{eventSchemaVersion}},
+	}
+	for m := range stdioMethods {
+		res.Capabilities.Methods = append(res.Capabilities.Methods, m)
+	}
+	sort.Strings(res.Capabilities.Methods)
+	return res, nil
+}
+
+func (p *stdioPeer) chat(ctx context.Context, req JSONRPCRequest) (any, error) {
 	var chatReq ChatRequest
 	if err := json.Unmarshal(req.Params, &chatReq); err != nil {
-		writeJSONRPCError(req.ID, "Invalid params", err.Error())
-		return
+		return nil, invalidParams(err)
+	}
+	if err := checkEventsVersion(chatReq.Events); err != nil {
+		return nil, invalidParams(err)
 	}
 
+	if chatReq.Events > 0 {
+		events := jsonrpcEvents(req.ID, "")
+		content, _, err := runSTDIOChat(ctx, chatReq.Messages, events.options(chatReq.Stream), chatReq.RequestOptions)
+		if err != nil {
+			return nil, err
+		}
+		events.done(content)
+		return ChatResponse{Content: content, Done: true}, nil
+	}
+
+	opts := jsonrpcOptions()
 	if chatReq.Stream {
-		if err := streamChat(chatReq.Messages, func(content string) {
+		// Streamed chunks are sent as partial results of the same request.
+		opts.OnContent = func(content string) {
 			writeJSONRPCResult(req.ID, ChatResponse{Content: content})
-		}); err != nil {
-			writeJSONRPCError(req.ID, "Chat error", err.Error())
-			return
 		}
-		writeJSONRPCResult(req.ID, ChatResponse{Done: true})
+	}
+	content, _, err := runSTDIOChat(ctx, chatReq.Messages, opts, chatReq.RequestOptions)
+	if err != nil {
+		return nil, err
+	}
+	if chatReq.Stream {
+		return ChatResponse{Done: true}, nil
+	}
+	return ChatResponse{Content: content, Done: true}, nil
+}
+
+// newSession starts a conversation. Its params are RequestOptions used by
+// every prompt of the session unless the prompt overrides them.
+func (p *stdioPeer) newSession(ctx context.Context, req JSONRPCRequest) (any, error) {
+	var options RequestOptions
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &options); err != nil {
+			return nil, invalidParams(err)
+		}
+		if err := options.apply(&engine.ChatOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextSession++
+	id := fmt.Sprintf("session-%d", p.nextSession)
+	p.sessions[id] = &stdioSession{options: options}
+	return ChatResponse{SessionID: id}, nil
+}
+
+func (p *stdioPeer) session(id string) (*stdioSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.sessions[id]
+	if !ok {
+		return nil, &JSONRPCError{Code: codeInvalidParams, Message: "Invalid params", Data: "unknown session: " + id}
+	}
+	return s, nil
+}
+
+// prompt adds messages to a session and runs the chat on its history. The
+// history is only updated when the chat completes.
+func (p *stdioPeer) prompt(ctx context.Context, req JSONRPCRequest) (any, error) {
+	var params SessionPromptRequest
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+	s, err := p.session(params.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	messages := params.Messages
+	if params.Prompt != "" {
+		messages = append(messages, engine.UserMessage(params.Prompt)...)
+	}
+	if len(messages) == 0 {
+		return nil, invalidParams(errors.New("prompt or messages is required"))
+	}
+	if err := checkEventsVersion(params.Events); err != nil {
+		return nil, invalidParams(err)
+	}
+	if !s.mu.TryLock() {
+		return nil, &JSONRPCError{Code: codeInvalidRequest, Message: "Invalid request", Data: "session is busy: " + params.SessionID}
+	}
+	defer s.mu.Unlock()
+
+	var opts engine.ChatOptions
+	var events *eventStream
+	if params.Events > 0 {
+		events = jsonrpcEvents(req.ID, params.SessionID)
+		opts = events.options(params.Stream)
 	} else {
-		result, err := completeChat(chatReq.Messages)
-		if err != nil {
-			writeJSONRPCError(req.ID, "Chat error", err.Error())
-			return
+		opts = jsonrpcOptions()
+		if params.Stream {
+			opts.OnContent = func(content string) {
+				writeJSONRPCNotification("session/update", ChatResponse{SessionID: params.SessionID, Content: content})
+			}
 		}
-		writeJSONRPCResult(req.ID, ChatResponse{Content: result, Done: true})
 	}
+	history := append(append([]openai.ChatCompletionMessage(nil), s.messages...), messages...)
+	content, history, err := runSTDIOChat(ctx, history, opts, params.RequestOptions.merge(s.options))
+	if err != nil {
+		return nil, err
+	}
+	s.messages = history
+	if events != nil {
+		events.done(content)
+	}
+	return ChatResponse{SessionID: params.SessionID, Content: content, Done: true}, nil
+}
+
+func (p *stdioPeer) closeSession(ctx context.Context, req JSONRPCRequest) (any, error) {
+	var params struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+	if _, err := p.session(params.SessionID); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	delete(p.sessions, params.SessionID)
+	p.mu.Unlock()
+	return ChatResponse{SessionID: params.SessionID, Done: true}, nil
+}
+
+// stdioCallTimeout bounds how long a request to the editor waits for an
+// answer. It is long because the editor may be asking the user.
+var stdioCallTimeout = 10 * time.Minute
+
+// call sends a JSON-RPC request to the editor and waits for its response. An
+// editor that does not know the method fails with errEditorUnavailable.
+func (p *stdioPeer) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	p.mu.Lock()
+	if !p.jsonrpc || p.closed {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", method, errEditorUnavailable)
+	}
+	p.nextID++
+	id := fmt.Sprintf("yagi-%d", p.nextID)
+	ch := make(chan stdioReply, 1)
+	p.pending[id] = ch
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	writeLine(JSONRPCRequest{JSONRPC: "2.0", ID: id, Method: method, Params: data})
+
+	timer := time.NewTimer(stdioCallTimeout)
+	defer timer.Stop()
+	select {
+	case reply := <-ch:
+		if reply.Error != nil && reply.Error.Code == codeMethodNotFound {
+			return nil, fmt.Errorf("%s: %w", method, errEditorUnavailable)
+		}
+		if reply.Error != nil {
+			return nil, fmt.Errorf("%s: %s", method, reply.Error.Message)
+		}
+		return reply.Result, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s: the editor did not answer within %s", method, stdioCallTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// supports reports whether the editor declared capability name. Editors that
+// skip initialize support nothing.
+func (p *stdioPeer) supports(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.clientCaps[name]
+	return ok
+}
+
+// deliver hands a response from the editor to the call waiting for it. It
+// reports false when line is not such a response.
+func (p *stdioPeer) deliver(line string) bool {
+	var msg struct {
+		ID     any    `json:"id"`
+		Method string `json:"method"`
+		stdioReply
+	}
+	if err := json.Unmarshal([]byte(line), &msg); err != nil || msg.Method != "" || msg.ID == nil {
+		return false
+	}
+	id := fmt.Sprint(msg.ID)
+	p.mu.Lock()
+	ch := p.pending[id]
+	delete(p.pending, id)
+	p.mu.Unlock()
+	if ch == nil {
+		return false
+	}
+	ch <- msg.stdioReply
+	return true
+}
+
+// elicit forwards an MCP elicitation to the editor as elicitation/create.
+// Editors that only speak the line-delimited protocol, or did not declare
+// the elicitation capability, cannot answer, so the request is cancelled.
+func (p *stdioPeer) elicit(ctx context.Context, server string, params *mcp.ElicitParams) (*mcp.ElicitResult, error) {
+	if !p.supports("elicitation") {
+		return &mcp.ElicitResult{Action: "cancel"}, nil
+	}
+	result, err := p.call(ctx, "elicitation/create", map[string]any{
+		"server":          server,
+		"mode":            params.Mode,
+		"message":         params.Message,
+		"requestedSchema": params.RequestedSchema,
+		"url":             params.URL,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: elicitation from %s cancelled: %v\n", server, err)
+		return &mcp.ElicitResult{Action: "cancel"}, nil
+	}
+	var res mcp.ElicitResult
+	if err := json.Unmarshal(result, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// approveTool asks the editor whether a tool may run. It returns
+// errEditorUnavailable when the editor cannot be asked.
+func (p *stdioPeer) approveTool(ctx context.Context, name, args, source string) (ToolApproveResult, error) {
+	if p.approve != nil {
+		return p.approve(ctx, name, args, source)
+	}
+	var res ToolApproveResult
+	if !p.supports("tool_approval") {
+		return res, errEditorUnavailable
+	}
+	result, err := p.call(ctx, "tool/approve", ToolApproveRequest{Name: name, Arguments: args, Source: source})
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(result, &res)
+	return res, err
+}
+
+// approveSampling asks the editor with sampling/approve whether an MCP server
+// may use the model. It returns errEditorUnavailable when the editor did not
+// declare the sampling capability.
+func (p *stdioPeer) approveSampling(ctx context.Context, req SamplingApproveRequest) (bool, error) {
+	if !p.supports("sampling") {
+		return false, errEditorUnavailable
+	}
+	result, err := p.call(ctx, "sampling/approve", req)
+	if err != nil {
+		return false, err
+	}
+	var res SamplingApproveResult
+	err = json.Unmarshal(result, &res)
+	return res.Approved, err
 }
 
 func handleLineDelimited(line string) {
@@ -115,84 +711,177 @@ func handleLineDelimited(line string) {
 		return
 	}
 
+	if err := checkEventsVersion(chatReq.Events); err != nil {
+		writeLine(ChatResponse{Error: "Invalid request: " + err.Error()})
+		return
+	}
+	if chatReq.Events > 0 {
+		events := lineEvents()
+		content, _, err := runSTDIOChat(context.Background(), chatReq.Messages, events.options(chatReq.Stream), chatReq.RequestOptions)
+		if err != nil {
+			events.fail(err)
+			return
+		}
+		events.done(content)
+		return
+	}
+
+	opts := lineOptions()
 	if chatReq.Stream {
-		if err := streamChat(chatReq.Messages, func(content string) {
+		opts.OnContent = func(content string) {
 			writeLine(ChatResponse{Content: content})
-		}); err != nil {
-			writeLine(ChatResponse{Error: err.Error()})
-			return
 		}
+	}
+	content, _, err := runSTDIOChat(context.Background(), chatReq.Messages, opts, chatReq.RequestOptions)
+	if err != nil {
+		writeLine(ChatResponse{Error: err.Error()})
+		return
+	}
+	if chatReq.Stream {
 		writeLine(ChatResponse{Done: true})
 	} else {
-		result, err := completeChat(chatReq.Messages)
-		if err != nil {
-			writeLine(ChatResponse{Error: err.Error()})
-			return
-		}
-		writeLine(ChatResponse{Content: result, Done: true})
+		writeLine(ChatResponse{Content: content, Done: true})
 	}
 }
 
-func onToolResultSTDIO(name, result string) {
-	writeLine(ChatResponse{ToolResult: &ToolResultResponse{Name: name, Output: result}})
+// lineOptions reports tool output in the original line-delimited format.
+func lineOptions() engine.ChatOptions {
+	return engine.ChatOptions{
+		OnToolProgress: func(name, text string) {
+			writeLine(ChatResponse{ToolProgress: &ToolResultResponse{Name: name, Output: text}})
+		},
+		OnToolOutput: func(name string, output engine.ToolOutput) {
+			writeLine(ChatResponse{ToolResult: toolResultResponse(name, output)})
+		},
+	}
 }
 
-func streamChat(messages []openai.ChatCompletionMessage, onChunk func(string)) error {
-	ctx := context.Background()
-	opts := engine.ChatOptions{
-		OnContent: func(text string) {
-			onChunk(text)
+// jsonrpcOptions reports tool output as tool/progress and tool/result
+// notifications.
+func jsonrpcOptions() engine.ChatOptions {
+	return engine.ChatOptions{
+		OnToolProgress: func(name, text string) {
+			writeJSONRPCNotification("tool/progress", ToolResultResponse{Name: name, Output: text})
+		},
+		OnToolOutput: func(name string, output engine.ToolOutput) {
+			writeJSONRPCNotification("tool/result", toolResultResponse(name, output))
 		},
-		OnToolResult: onToolResultSTDIO,
-		Autonomous:   true,
 	}
-	_, _, err := eng.Chat(ctx, messages, opts)
-	return err
 }
 
-func completeChat(messages []openai.ChatCompletionMessage) (string, error) {
-	ctx := context.Background()
-	var fullContent strings.Builder
-	opts := engine.ChatOptions{
-		OnContent: func(text string) {
-			fullContent.WriteString(text)
-		},
-		OnToolResult: onToolResultSTDIO,
-		Autonomous:   true,
+func toolResultResponse(name string, output engine.ToolOutput) *ToolResultResponse {
+	return &ToolResultResponse{
+		Name:       name,
+		Output:     output.Text,
+		Images:     output.Images,
+		Structured: output.Structured,
 	}
-	_, _, err := eng.Chat(ctx, messages, opts)
-	if err != nil {
-		return "", err
+}
+
+func runSTDIOChat(ctx context.Context, messages []openai.ChatCompletionMessage, opts engine.ChatOptions, options RequestOptions) (string, []openai.ChatCompletionMessage, error) {
+	if err := options.apply(&opts); err != nil {
+		return "", messages, err
+	}
+	opts.Autonomous = true
+	return eng.Chat(ctx, messages, opts)
+}
+
+// merge returns o with the fields it leaves unset taken from defaults.
+func (o RequestOptions) merge(defaults RequestOptions) RequestOptions {
+	if o.Model == "" {
+		o.Model = defaults.Model
+	}
+	if o.Skill == "" {
+		o.Skill = defaults.Skill
+	}
+	if o.Temperature == 0 {
+		o.Temperature = defaults.Temperature
+	}
+	if o.TopP == 0 {
+		o.TopP = defaults.TopP
+	}
+	if o.MaxTokens == 0 {
+		o.MaxTokens = defaults.MaxTokens
+	}
+	if o.Tools == nil {
+		o.Tools = defaults.Tools
+	}
+	if o.MaxIterations == 0 {
+		o.MaxIterations = defaults.MaxIterations
+	}
+	return o
+}
+
+// apply validates the options and sets them on opts.
+func (o RequestOptions) apply(opts *engine.ChatOptions) error {
+	pinActiveModel(opts)
+	if o.Model != "" {
+		client, model, err := newModelClient(o.Model)
+		if err != nil {
+			return invalidParams(err)
+		}
+		opts.Client, opts.Model = client, model
+		opts.ModelName = o.Model
+		opts.Vision = supportsVision(o.Model)
 	}
-	return fullContent.String(), nil
+	if o.Skill != "" {
+		if _, ok := skillPrompts[o.Skill]; !ok {
+			return invalidParams(fmt.Errorf("unknown skill: %s", o.Skill))
+		}
+		opts.Skill = o.Skill
+	}
+	for _, name := range o.Tools {
+		if !eng.HasTool(name) {
+			return invalidParams(fmt.Errorf("unknown tool: %s", name))
+		}
+	}
+	opts.Tools = o.Tools
+	opts.Temperature = o.Temperature
+	opts.TopP = o.TopP
+	opts.MaxTokens = o.MaxTokens
+	opts.MaxIterations = o.MaxIterations
+	return nil
+}
+
+// plainError returns the detail of an invalid params error from apply, for
+// callers that do not speak JSON-RPC.
+func plainError(err error) error {
+	var rpcErr *JSONRPCError
+	if errors.As(err, &rpcErr) && rpcErr.Data != nil {
+		return fmt.Errorf("%v", rpcErr.Data)
+	}
+	return err
 }
 
 func writeJSONRPCResult(id interface{}, result interface{}) {
-	resp := JSONRPCResponse{
+	writeLine(JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      id,
 		Result:  result,
-	}
-	data, _ := json.Marshal(resp)
-	fmt.Println(string(data))
+	})
 }
 
-func writeJSONRPCError(id interface{}, message string, data interface{}) {
-	resp := JSONRPCResponse{
+func writeJSONRPCNotification(method string, params interface{}) {
+	writeLine(JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
+
+func writeJSONRPCError(id interface{}, err *JSONRPCError) {
+	writeLine(JSONRPCResponse{
 		JSONRPC: "2.0",
 		ID:      id,
-		Error: map[string]interface{}{
-			"message": message,
-			"data":    data,
-		},
-	}
-	respData, _ := json.Marshal(resp)
-	fmt.Println(string(respData))
+		Error:   err,
+	})
 }
 
 func writeLine(data interface{}) {
 	jsonData, _ := json.Marshal(data)
-	fmt.Println(string(jsonData))
+	stdioOutMu.Lock()
+	defer stdioOutMu.Unlock()
+	fmt.Fprintln(stdioOut, string(jsonData))
 }
 
 func writeError(message string) {
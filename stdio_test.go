@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
 )
 
 func TestJSONRPCRequest_Marshal(t *testing.T) {
@@ -99,6 +106,23 @@ func TestChatResponse_MarshalOmitsEmpty(t *testing.T) {
 	}
 }
 
+func TestChatResponse_MarshalToolResult(t *testing.T) {
+	resp := ChatResponse{ToolResult: &ToolResultResponse{
+		Name:       "snapshot",
+		Output:     "ok",
+		Images:     []engine.ToolImage{{MIMEType: "image/png", Data: []byte("PNG")}},
+		Structured: map[string]any{"width": 640},
+	}}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{"tool_result":{"name":"snapshot","output":"ok","images":[{"mime_type":"image/png","data":"UE5H"}],"structured":{"width":640}}}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
 func TestChatRequest_Unmarshal(t *testing.T) {
 	input := `{"messages":[{"role":"user","content":"hi"}],"stream":true,"model":"gpt-4"}`
 	var req ChatRequest
@@ -121,3 +145,441 @@ func TestChatRequest_Unmarshal(t *testing.T) {
 		t.Errorf("Model = %q, want \"gpt-4\"", req.Model)
 	}
 }
+
+// stdioTestClient plays the editor against a stdioPeer serving over pipes.
+type stdioTestClient struct {
+	t     *testing.T
+	in    *io.PipeWriter
+	lines chan map[string]any
+}
+
+func startSTDIOPeer(t *testing.T) *stdioTestClient {
+	t.Helper()
+	return startPeer(t, newSTDIOPeer())
+}
+
+// startPeer serves peer over pipes and makes it the editor peer.
+func startPeer(t *testing.T, peer *stdioPeer) *stdioTestClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	savedOut, savedPeer := stdioOut, editorPeer
+	stdioOut, editorPeer = outW, peer
+
+	c := &stdioTestClient{t: t, in: inW, lines: make(chan map[string]any, 100)}
+	go func() {
+		defer close(c.lines)
+		scanner := bufio.NewScanner(outR)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var m map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+				t.Errorf("invalid output %q: %v", scanner.Text(), err)
+				continue
+			}
+			c.lines <- m
+		}
+	}()
+	done := make(chan error, 1)
+	go func() { done <- peer.serve(inR) }()
+
+	t.Cleanup(func() {
+		inW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+		outW.Close()
+		stdioOut, editorPeer = savedOut, savedPeer
+	})
+	return c
+}
+
+func (c *stdioTestClient) send(line string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.in, line+"\n"); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *stdioTestClient) recv() map[string]any {
+	c.t.Helper()
+	select {
+	case m := <-c.lines:
+		return m
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("timed out waiting for output")
+		return nil
+	}
+}
+
+// response skips notifications until the response to id arrives.
+func (c *stdioTestClient) response(id float64) map[string]any {
+	c.t.Helper()
+	for {
+		m := c.recv()
+		if m["id"] == id {
+			return m
+		}
+	}
+}
+
+func errorCode(m map[string]any) float64 {
+	e, _ := m["error"].(map[string]any)
+	code, _ := e["code"].(float64)
+	return code
+}
+
+func TestSTDIO_InitializeAndErrors(t *testing.T) {
+	c := startSTDIOPeer(t)
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocol_version":"1","client_info":{"name":"editor"},"capabilities":{}}}`)
+	res := c.response(1)["result"].(map[string]any)
+	methods := fmt.Sprint(res["capabilities"].(map[string]any)["methods"])
+	if res["protocol_version"] != stdioProtocolVersion || !strings.Contains(methods, "session/prompt") {
+		t.Errorf("initialize = %v", res)
+	}
+	if editorPeer.supports("elicitation") {
+		t.Error("elicitation should not be supported when the editor did not declare it")
+	}
+
+	tests := []struct {
+		line string
+		id   any
+		code float64
+	}{
+		{`{"jsonrpc":"2.0","id":2,"method":"nope"}`, 2.0, codeMethodNotFound},
+		{`{"jsonrpc":"2.0","id":3,"method":"session/prompt","params":[]}`, 3.0, codeInvalidParams},
+		{`{"jsonrpc":"2.0","id":4,"method":"session/prompt","params":{"session_id":"missing","prompt":"hi"}}`, 4.0, codeInvalidParams},
+		{`{"jsonrpc":"2.0","id":5}`, 5.0, codeInvalidRequest},
+		{`{"jsonrpc":`, nil, codeParseError},
+	}
+	for _, tt := range tests {
+		c.send(tt.line)
+		m := c.recv()
+		if m["id"] != tt.id || errorCode(m) != tt.code {
+			t.Errorf("%s: got %v, want code %v", tt.line, m, tt.code)
+		}
+	}
+}
+
+func TestSTDIO_Sessions(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{Content: "first"}, fakeTurn{Content: "second"})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test", CompressThreshold: 1 << 20})
+	c := startSTDIOPeer(t)
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"session/new"}`)
+	sessionID := c.response(1)["result"].(map[string]any)["session_id"].(string)
+
+	// Lines are not limited in length.
+	long := strings.Repeat("x", 100*1024)
+	c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"session_id":%q,"prompt":%q}}`, sessionID, long))
+	if res := c.response(2)["result"].(map[string]any); res["content"] != "first" {
+		t.Errorf("first prompt = %v", res)
+	}
+
+	c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":3,"method":"session/prompt","params":{"session_id":%q,"prompt":"again","stream":true}}`, sessionID))
+	var streamed strings.Builder
+	for {
+		m := c.recv()
+		if m["method"] == "session/update" {
+			streamed.WriteString(m["params"].(map[string]any)["content"].(string))
+			continue
+		}
+		if m["id"] != 3.0 || m["result"].(map[string]any)["content"] != "second" {
+			t.Errorf("second prompt = %v", m)
+		}
+		break
+	}
+	if streamed.String() != "second" {
+		t.Errorf("streamed %q, want second", streamed.String())
+	}
+
+	reqs := llm.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(reqs))
+	}
+	var roles []string
+	for _, m := range reqs[1].Messages {
+		roles = append(roles, m.Role)
+	}
+	if fmt.Sprint(roles) != "[user assistant user]" || reqs[1].Messages[0].Content != long || reqs[1].Messages[2].Content != "again" {
+		t.Errorf("expected the session history to be sent, got roles %v", roles)
+	}
+
+	c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":4,"method":"session/close","params":{"session_id":%q}}`, sessionID))
+	c.response(4)
+	c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":5,"method":"session/prompt","params":{"session_id":%q,"prompt":"hi"}}`, sessionID))
+	if m := c.response(5); errorCode(m) != codeInvalidParams {
+		t.Errorf("prompt after close = %v", m)
+	}
+}
+
+func TestSTDIO_CancelRequest(t *testing.T) {
+	llm := newFakeLLM(t, fakeTurn{ToolCalls: []openai.ToolCall{toolCall("wait", "{}")}})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	started := make(chan struct{})
+	eng.RegisterTool("wait", "Wait until cancelled", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}, true)
+	c := startSTDIOPeer(t)
+
+	c.send(`{"jsonrpc":"2.0","id":"slow","method":"chat","params":{"messages":[{"role":"user","content":"wait"}]}}`)
+	<-started
+
+	// Other requests are answered while the chat is running.
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)
+	if m := c.response(1); m["result"] == nil {
+		t.Errorf("initialize during chat = %v", m)
+	}
+
+	c.send(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"slow"}}`)
+	for {
+		m := c.recv()
+		if m["id"] != "slow" {
+			continue
+		}
+		if errorCode(m) != codeRequestCancelled {
+			t.Errorf("cancelled chat = %v", m)
+		}
+		break
+	}
+}
+
+func TestSTDIO_ToolApproval(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("touch", "{}")}}, fakeTurn{Content: "1"},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("touch", "{}")}}, fakeTurn{Content: "2"},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("touch", "{}")}}, fakeTurn{Content: "3"},
+	)
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test", Approver: newToolApprover()})
+	eng.RegisterTool("touch", "Touch a file", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return "touched", nil
+	}, false)
+	c := startSTDIOPeer(t)
+
+	c.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"capabilities":{"tool_approval":{}}}}`)
+	c.response(0)
+
+	// Deny, then approve always, then run without asking.
+	for i, answer := range []string{`{"approved":false}`, `{"approved":true,"always":true}`, ""} {
+		id := float64(i + 1)
+		c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"method":"chat","params":{"messages":[{"role":"user","content":"touch"}]}}`, id))
+		for {
+			m := c.recv()
+			if m["method"] == "tool/approve" {
+				params := m["params"].(map[string]any)
+				if answer == "" || params["name"] != "touch" || params["arguments"] != "{}" {
+					t.Errorf("chat %d: unexpected approval request %v", i, m)
+				}
+				c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":%s}`, m["id"], answer))
+				continue
+			}
+			if m["id"] == id {
+				break
+			}
+		}
+	}
+
+	reqs := llm.Requests()
+	var results []string
+	for _, i := range []int{1, 3, 5} {
+		msgs := reqs[i].Messages
+		results = append(results, msgs[len(msgs)-1].Content)
+	}
+	if fmt.Sprint(results) != "[Error: Tool not approved by user touched touched]" {
+		t.Errorf("tool results = %q", results)
+	}
+}
+
+func TestSTDIO_ToolApprovalUnavailable(t *testing.T) {
+	for _, tt := range []struct {
+		name, init string
+	}{
+		{"no initialize", ""},
+		{"method not found", `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"capabilities":{"tool_approval":{}}}}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := newFakeLLM(t, fakeTurn{ToolCalls: []openai.ToolCall{toolCall("touch", "{}")}}, fakeTurn{Content: "done"})
+			eng = engine.New(engine.Config{Client: llm.client(), Model: "test", Approver: newToolApprover()})
+			var ran bool
+			eng.RegisterTool("touch", "Touch a file", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+				ran = true
+				return "touched", nil
+			}, false)
+			c := startSTDIOPeer(t)
+			if tt.init != "" {
+				c.send(tt.init)
+				c.response(0)
+			}
+
+			c.send(`{"jsonrpc":"2.0","id":1,"method":"chat","params":{"messages":[{"role":"user","content":"touch"}]}}`)
+			for {
+				m := c.recv()
+				if m["method"] == "tool/approve" {
+					if tt.init == "" {
+						t.Errorf("asked an editor that never initialized: %v", m)
+					}
+					c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"error":{"code":-32601,"message":"Method not found"}}`, m["id"]))
+					continue
+				}
+				if m["id"] == 1.0 {
+					if m["error"] != nil {
+						t.Errorf("chat failed: %v", m)
+					}
+					break
+				}
+			}
+			if !ran {
+				t.Error("tool did not run")
+			}
+		})
+	}
+}
+
+func TestSTDIO_Events(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("echo", `{"text":"hi"}`), toolCall("fail", "{}")}},
+		fakeTurn{Content: "ok"},
+		fakeTurn{Content: "again"},
+	)
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	eng.RegisterTool("echo", "Echo", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return args, nil
+	}, true)
+	eng.RegisterTool("fail", "Fail", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return "", fmt.Errorf("broken")
+	}, true)
+	c := startSTDIOPeer(t)
+
+	c.send(`{"messages":[{"role":"user","content":"go"}],"stream":true,"events":1}`)
+	var types []string
+	var done map[string]any
+	for done == nil {
+		m := c.recv()
+		if m["version"] != float64(eventSchemaVersion) {
+			t.Errorf("event without version: %v", m)
+		}
+		types = append(types, m["type"].(string))
+		switch m["type"] {
+		case eventToolCall:
+			if m["name"] == "echo" && m["arguments"] != `{"text":"hi"}` {
+				t.Errorf("tool_call = %v", m)
+			}
+		case eventToolError:
+			if m["name"] != "fail" || !strings.Contains(m["error"].(string), "broken") {
+				t.Errorf("tool_error = %v", m)
+			}
+		case eventDone:
+			done = m
+		}
+	}
+	want := "[usage tool_call tool_call tool_result tool_error content usage done]"
+	if fmt.Sprint(types) != want {
+		t.Errorf("events = %v, want %s", types, want)
+	}
+	if done["content"] != "ok" || done["usage"].(map[string]any)["total_tokens"] != 30.0 {
+		t.Errorf("done = %v", done)
+	}
+
+	// In JSON-RPC the same events are notifications tied to the request.
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"session/new"}`)
+	sessionID := c.response(1)["result"].(map[string]any)["session_id"].(string)
+	c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":2,"method":"session/prompt","params":{"session_id":%q,"prompt":"again","events":1}}`, sessionID))
+	types = nil
+	for {
+		m := c.recv()
+		if m["method"] != "event" {
+			if m["id"] != 2.0 || m["result"] == nil {
+				t.Errorf("unexpected message %v", m)
+			}
+			break
+		}
+		ev := m["params"].(map[string]any)
+		if ev["request_id"] != 2.0 || ev["session_id"] != sessionID {
+			t.Errorf("event not tied to the request: %v", ev)
+		}
+		types = append(types, ev["type"].(string))
+	}
+	if fmt.Sprint(types) != "[usage done]" {
+		t.Errorf("events = %v, want [usage done] without streamed content", types)
+	}
+
+	c.send(`{"jsonrpc":"2.0","id":3,"method":"chat","params":{"messages":[],"events":2}}`)
+	if m := c.response(3); errorCode(m) != codeInvalidParams {
+		t.Errorf("unsupported events version = %v", m)
+	}
+}
+
+func TestSTDIO_RequestOptions(t *testing.T) {
+	llm := newFakeLLM(t)
+	other := newFakeLLM(t, fakeTurn{Content: "from other"})
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	for _, name := range []string{"a", "b"} {
+		eng.RegisterTool(name, name, json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+			return "", nil
+		}, true)
+	}
+	savedProviders, savedSkills := providers, skillPrompts
+	providers = []Provider{{Name: "other", APIURL: other.srv.URL + "/v1"}}
+	skillPrompts = map[string]string{"terse": "Be terse."}
+	defer func() { providers, skillPrompts = savedProviders, savedSkills }()
+	c := startSTDIOPeer(t)
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"chat","params":{"messages":[{"role":"user","content":"hi"}],"model":"other/big","skill":"terse","temperature":0.5,"top_p":0.9,"max_tokens":64,"tools":["b"]}}`)
+	if res := c.response(1)["result"].(map[string]any); res["content"] != "from other" {
+		t.Errorf("chat = %v", res)
+	}
+	reqs := other.Requests()
+	if len(reqs) != 1 || len(llm.Requests()) != 0 {
+		t.Fatalf("expected the request to go to the other provider only")
+	}
+	r := reqs[0]
+	if r.Model != "big" || r.Temperature != 0.5 || r.TopP != 0.9 || r.MaxTokens != 64 ||
+		len(r.Tools) != 1 || r.Tools[0].Function.Name != "b" {
+		t.Errorf("request = %+v", r)
+	}
+	if eng.Model() != "test" {
+		t.Errorf("global model changed to %q", eng.Model())
+	}
+
+	// Sessions keep their options; prompts override them.
+	c.send(`{"jsonrpc":"2.0","id":2,"method":"session/new","params":{"tools":[],"max_tokens":10}}`)
+	sessionID := c.response(2)["result"].(map[string]any)["session_id"].(string)
+	c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":3,"method":"session/prompt","params":{"session_id":%q,"prompt":"hi","max_tokens":20}}`, sessionID))
+	c.response(3)
+	if r := llm.Requests()[0]; len(r.Tools) != 0 || r.MaxTokens != 20 {
+		t.Errorf("session request = %+v", r)
+	}
+
+	for i, params := range []string{`"model":"nope/x"`, `"skill":"missing"`, `"tools":["missing"]`} {
+		id := i + 10
+		c.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"chat","params":{"messages":[{"role":"user","content":"hi"}],%s}}`, id, params))
+		if m := c.response(float64(id)); errorCode(m) != codeInvalidParams {
+			t.Errorf("%s: got %v", params, m)
+		}
+	}
+}
+
+func TestSTDIO_MaxIterations(t *testing.T) {
+	llm := newFakeLLM(t,
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("a", "{}")}},
+		fakeTurn{ToolCalls: []openai.ToolCall{toolCall("a", "{}")}},
+	)
+	eng = engine.New(engine.Config{Client: llm.client(), Model: "test"})
+	eng.RegisterTool("a", "a", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		return "", nil
+	}, true)
+	c := startSTDIOPeer(t)
+
+	c.send(`{"messages":[{"role":"user","content":"loop"}],"max_iterations":1}`)
+	for {
+		if m := c.recv(); m["done"] == true {
+			break
+		}
+	}
+	if n := len(llm.Requests()); n != 1 {
+		t.Errorf("expected 1 model call with max_iterations 1, got %d", n)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/yagi-agent/yagi/engine"
+)
+
+// eventSchemaVersion is the version of StreamEvent. Requests opt in to the
+// event stream with "events": eventSchemaVersion; fields are only added within
+// a version.
+const eventSchemaVersion = 1
+
+// Event types.
+const (
+	eventContent      = "content"
+	eventReasoning    = "reasoning"
+	eventToolCall     = "tool_call"
+	eventToolProgress = "tool_progress"
+	eventToolResult   = "tool_result"
+	eventToolError    = "tool_error"
+	eventCompressed   = "compressed"
+	eventModel        = "model"
+	eventUsage        = "usage"
+	eventDone         = "done"
+	eventError        = "error"
+)
+
+// StreamEvent is one step of a chat in STDIO mode. It is written as a line
+// in the line-delimited protocol and as the params of an "event"
+// notification in JSON-RPC.
+type StreamEvent struct {
+	Version   int         `json:"version"`
+	Type      string      `json:"type"`
+	RequestID interface{} `json:"request_id,omitempty"`
+	SessionID string      `json:"session_id,omitempty"`
+
+	// Text is set for content, reasoning and tool_progress, and is the
+	// reason in model; Content is the full answer in done.
+	Text    string `json:"text,omitempty"`
+	Content string `json:"content,omitempty"`
+
+	// Tool events.
+	Name       string             `json:"name,omitempty"`
+	Arguments  string             `json:"arguments,omitempty"`
+	Output     string             `json:"output,omitempty"`
+	Images     []engine.ToolImage `json:"images,omitempty"`
+	Structured any                `json:"structured,omitempty"`
+
+	Error           string      `json:"error,omitempty"`
+	CompressedChars int         `json:"compressed_chars,omitempty"`
+	Usage           *EventUsage `json:"usage,omitempty"`
+}
+
+type EventUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func checkEventsVersion(v int) error {
+	if v != 0 && v != eventSchemaVersion {
+		return fmt.Errorf("unsupported events version %d (supported: %d)", v, eventSchemaVersion)
+	}
+	return nil
+}
+
+// eventStream reports a chat as StreamEvents. usage adds up the usage events
+// for done.
+type eventStream struct {
+	emit  func(StreamEvent)
+	usage EventUsage
+}
+
+func lineEvents() *eventStream {
+	return &eventStream{emit: func(ev StreamEvent) {
+		ev.Version = eventSchemaVersion
+		writeLine(ev)
+	}}
+}
+
+func jsonrpcEvents(id interface{}, sessionID string) *eventStream {
+	return &eventStream{emit: func(ev StreamEvent) {
+		ev.Version = eventSchemaVersion
+		ev.RequestID = id
+		ev.SessionID = sessionID
+		writeJSONRPCNotification("event", ev)
+	}}
+}
+
+// options returns chat options emitting every event. Content is only
+// streamed when stream is set; done carries it either way.
+func (s *eventStream) options(stream bool) engine.ChatOptions {
+	opts := engine.ChatOptions{
+		OnReasoning: func(text string) {
+			s.emit(StreamEvent{Type: eventReasoning, Text: text})
+		},
+		OnToolCall: func(name, arguments string) {
+			s.emit(StreamEvent{Type: eventToolCall, Name: name, Arguments: arguments})
+		},
+		OnToolProgress: func(name, text string) {
+			s.emit(StreamEvent{Type: eventToolProgress, Name: name, Text: text})
+		},
+		OnToolOutput: func(name string, output engine.ToolOutput) {
+			s.emit(StreamEvent{Type: eventToolResult, Name: name, Output: output.Text, Images: output.Images, Structured: output.Structured})
+		},
+		OnToolError: func(name, errMsg string) {
+			s.emit(StreamEvent{Type: eventToolError, Name: name, Error: errMsg})
+		},
+		OnCompressed: func(oldChars int) {
+			s.emit(StreamEvent{Type: eventCompressed, CompressedChars: oldChars})
+		},
+		OnModel: func(name, reason string) {
+			s.emit(StreamEvent{Type: eventModel, Name: name, Text: reason})
+		},
+		OnUsage: func(u openai.Usage) {
+			usage := EventUsage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+			s.usage.PromptTokens += usage.PromptTokens
+			s.usage.CompletionTokens += usage.CompletionTokens
+			s.usage.TotalTokens += usage.TotalTokens
+			s.emit(StreamEvent{Type: eventUsage, Usage: &usage})
+		},
+	}
+	if stream {
+		opts.OnContent = func(text string) {
+			s.emit(StreamEvent{Type: eventContent, Text: text})
+		}
+	}
+	return opts
+}
+
+func (s *eventStream) done(content string) {
+	usage := s.usage
+	s.emit(StreamEvent{Type: eventDone, Content: content, Usage: &usage})
+}
+
+func (s *eventStream) fail(err error) {
+	s.emit(StreamEvent{Type: eventError, Error: err.Error()})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+type toolSource struct {
+	Kind   string // "built-in", "plugin" or "mcp"
+	Origin string // plugin file path or MCP server name
+}
+
+var (
+	toolSourcesMu sync.Mutex
+	toolSources   = map[string]toolSource{}
+)
+
+func setToolSource(name, kind, origin string) {
+	toolSourcesMu.Lock()
+	defer toolSourcesMu.Unlock()
+	toolSources[name] = toolSource{Kind: kind, Origin: origin}
+}
+
+func getToolSource(name string) toolSource {
+	toolSourcesMu.Lock()
+	defer toolSourcesMu.Unlock()
+	return toolSources[name]
+}
+
+func (s toolSource) String() string {
+	if s.Kind == "" {
+		return "unknown"
+	}
+	if s.Origin == "" {
+		return s.Kind
+	}
+	return s.Kind + " (" + s.Origin + ")"
+}
+
+func validatePluginFile(path string) (*pluginTool, error) {
+	pt, err := evalPlugin(path)
+	if err != nil {
+		return nil, err
+	}
+	if pt.Name == "" {
+		return nil, fmt.Errorf("Tool.Name is empty")
+	}
+	if strings.TrimSpace(pt.Description) == "" {
+		return nil, fmt.Errorf("Tool.Description is empty")
+	}
+	if err := engine.ValidateSchema(json.RawMessage(pt.Parameters)); err != nil {
+		return nil, fmt.Errorf("Tool.Parameters: %w", err)
+	}
+	t := pt.Run.Type()
+	if t.NumIn() != 2 || t.NumOut() < 1 || t.NumOut() > 2 {
+		return nil, fmt.Errorf("Tool.Run must be func(context.Context, string) (string, error), got %s", t)
+	}
+	return pt, nil
+}
+
+func runToolCommand(args []string) error {
+	usage := fmt.Errorf("usage: yagi tool list | run <name> '<json-args>' | validate <file...>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "list":
+		for _, t := range eng.Tools() {
+			desc, _, _ := strings.Cut(t.Function.Description, "\n")
+			fmt.Printf("%-24s %-32s %s\n", t.Function.Name, getToolSource(t.Function.Name), desc)
+		}
+	case "run":
+		if len(args) < 2 || len(args) > 3 {
+			return usage
+		}
+		name := args[1]
+		if !eng.HasTool(name) {
+			return fmt.Errorf("unknown tool: %s", name)
+		}
+		// No provider is set up for tool commands.
+		if name == delegateToolName {
+			return fmt.Errorf("%s needs a model and cannot run outside a chat", name)
+		}
+		arguments := "{}"
+		if len(args) == 3 {
+			arguments = args[2]
+		}
+		result, isErr := eng.CallTool(context.Background(), name, arguments)
+		if isErr {
+			return errors.New(result)
+		}
+		fmt.Println(result)
+	case "validate":
+		if len(args) < 2 {
+			return usage
+		}
+		failed := false
+		for _, path := range args[1:] {
+			pt, err := validatePluginFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: ok (%s)\n", path, pt.Name)
+		}
+		if failed {
+			return fmt.Errorf("validation failed")
+		}
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yagi-agent/yagi/engine"
+)
+
+func writePluginFile(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tool.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidatePluginFile_Valid(t *testing.T) {
+	pt, err := validatePluginFile(writePluginFile(t, testPluginSource))
+	if err != nil {
+		t.Fatalf("validatePluginFile: %v", err)
+	}
+	if pt.Name != "echo_back" {
+		t.Errorf("Name = %q, want %q", pt.Name, "echo_back")
+	}
+}
+
+func TestValidatePluginFile_InvalidSchema(t *testing.T) {
+	src := strings.Replace(testPluginSource, `{"type":"object","properties":{}}`, `{"type":"object","properties":`, 1)
+	_, err := validatePluginFile(writePluginFile(t, src))
+	if err == nil || !strings.Contains(err.Error(), "Tool.Parameters") {
+		t.Errorf("expected Parameters error, got %v", err)
+	}
+}
+
+func TestValidatePluginFile_NotObjectSchema(t *testing.T) {
+	src := strings.Replace(testPluginSource, `{"type":"object","properties":{}}`, `{"type":"string"}`, 1)
+	_, err := validatePluginFile(writePluginFile(t, src))
+	if err == nil || !strings.Contains(err.Error(), `"object"`) {
+		t.Errorf("expected object type error, got %v", err)
+	}
+}
+
+func TestValidatePluginFile_MissingField(t *testing.T) {
+	src := strings.Replace(testPluginSource, "\tDescription string\n", "", 1)
+	src = strings.Replace(src, "\tDescription: \"Echo the arguments back\",\n", "", 1)
+	_, err := validatePluginFile(writePluginFile(t, src))
+	if err == nil || !strings.Contains(err.Error(), "Tool.Description") {
+		t.Errorf("expected Description error, got %v", err)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		schema  string
+		wantErr bool
+	}{
+		{`{"type":"object","properties":{"a":{"type":"string"}},"required":["a"]}`, false},
+		{`{"type":"object"}`, false},
+		{`{}`, true},
+		{``, true},
+		{`{"type":"object","required":"a"}`, true},
+	}
+	for _, tt := range tests {
+		err := engine.ValidateSchema(json.RawMessage(tt.schema))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateSchema(%q) error = %v, wantErr %v", tt.schema, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadPlugin_RecordsSource(t *testing.T) {
+	eng = engine.New(engine.Config{})
+	path := writePluginFile(t, testPluginSource)
+	approvals := &approvalRecord{Directories: make(map[string][]string)}
+	if err := loadPlugin(path, t.TempDir(), t.TempDir(), approvals); err != nil {
+		t.Fatalf("loadPlugin: %v", err)
+	}
+	src := getToolSource("echo_back")
+	if src.Kind != "plugin" || src.Origin != path {
+		t.Errorf("toolSources[echo_back] = %+v, want plugin (%s)", src, path)
+	}
+	if got := src.String(); got != "plugin ("+path+")" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestRunToolCommand_Run(t *testing.T) {
+	eng = engine.New(engine.Config{})
+	eng.RegisterTool("check", "Check", json.RawMessage(`{"type":"object"}`), func(ctx context.Context, args string) (string, error) {
+		if args == "{}" {
+			return "ok", nil
+		}
+		return "", errors.New("bad input")
+	}, true)
+
+	if err := runToolCommand([]string{"run", "check"}); err != nil {
+		t.Errorf("passing tool: %v", err)
+	}
+	if err := runToolCommand([]string{"run", "check", `{"x":1}`}); err == nil || !strings.Contains(err.Error(), "bad input") {
+		t.Errorf("failing tool: err = %v", err)
+	}
+}